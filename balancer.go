@@ -0,0 +1,79 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+)
+
+// Balancer picks a poller for a new conn.
+type Balancer interface {
+	// Pick returns the index of the poller that would serve c, in [0, g.PollerNum()).
+	Pick(g *Gopher, c *Conn) int
+}
+
+// RoundRobinBalancer picks pollers in turn.
+type RoundRobinBalancer struct {
+	next uint32
+}
+
+// NewRoundRobinBalancer .
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{}
+}
+
+// Pick implements Balancer.
+func (b *RoundRobinBalancer) Pick(g *Gopher, c *Conn) int {
+	return int((atomic.AddUint32(&b.next, 1) - 1) % uint32(g.PollerNum()))
+}
+
+// LeastConnBalancer picks the poller serving the fewest conns.
+type LeastConnBalancer struct{}
+
+// NewLeastConnBalancer .
+func NewLeastConnBalancer() *LeastConnBalancer {
+	return &LeastConnBalancer{}
+}
+
+// Pick implements Balancer.
+func (b *LeastConnBalancer) Pick(g *Gopher, c *Conn) int {
+	idx, least := 0, g.pollerConnNum(0)
+	for i := 1; i < g.PollerNum() && least > 0; i++ {
+		if n := g.pollerConnNum(i); n < least {
+			idx, least = i, n
+		}
+	}
+	return idx
+}
+
+// IPHashBalancer picks the poller by the hash of the remote ip,
+// so conns from the same client are served by the same poller.
+type IPHashBalancer struct{}
+
+// NewIPHashBalancer .
+func NewIPHashBalancer() *IPHashBalancer {
+	return &IPHashBalancer{}
+}
+
+// Pick implements Balancer.
+func (b *IPHashBalancer) Pick(g *Gopher, c *Conn) int {
+	var ip net.IP
+	switch addr := c.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return c.Hash() % g.PollerNum()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	h := fnv.New32a()
+	h.Write(ip)
+	return int(h.Sum32() % uint32(g.PollerNum()))
+}
@@ -13,6 +13,37 @@ import (
 	"github.com/wubbalubbaaa/easyNet/logging"
 )
 
+// ConnStats represents the traffic and activity statistics of a Conn.
+type ConnStats struct {
+	// BytesRead and BytesWritten are the bytes read from and written to the socket.
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// FramesRead is the number of frames decoded by the codec, or reads passed to OnData without a codec.
+	// FramesWritten is the number of Write, Writev and WriteFds calls.
+	FramesRead    uint64
+	FramesWritten uint64
+
+	// WriteBufferLen is the length of data waiting to be written, PeakWriteBufferLen is the max of it.
+	WriteBufferLen     int
+	PeakWriteBufferLen int
+
+	// PartialWrites is the number of writes only partly done because the kernel sendQ is full.
+	PartialWrites uint64
+
+	// the zero time means never.
+	OpenTime      time.Time
+	LastReadTime  time.Time
+	LastWriteTime time.Time
+}
+
+func unixNanoToTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
 // OnData registers callback for data.
 func (c *Conn) OnData(h func(conn *Conn, data []byte)) {
 	c.DataHandler = h
@@ -36,6 +67,11 @@ func DialTimeout(network string, address string, timeout time.Duration) (*Conn,
 	return NBConn(conn)
 }
 
+// ID returns the unique id of the conn in its Gopher, it's 0 before the conn is added to a Gopher.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
 // Lock .
 func (c *Conn) Lock() {
 	c.mux.Lock()
@@ -72,32 +108,7 @@ func (c *Conn) Execute(f func()) {
 	c.mux.Unlock()
 
 	if isHead {
-		c.g.Execute(func() {
-			i := 0
-			for {
-				func() {
-					defer func() {
-						if err := recover(); err != nil {
-							const size = 64 << 10
-							buf := make([]byte, size)
-							buf = buf[:runtime.Stack(buf, false)]
-							logging.Error("conn execute failed: %v\n%v\n", err, *(*string)(unsafe.Pointer(&buf)))
-						}
-					}()
-					f()
-				}()
-
-				c.mux.Lock()
-				i++
-				if len(c.execList) == i {
-					c.execList = c.execList[0:0]
-					c.mux.Unlock()
-					return
-				}
-				f = c.execList[i]
-				c.mux.Unlock()
-			}
-		})
+		c.execHead(f, true)
 	}
 }
 
@@ -109,21 +120,58 @@ func (c *Conn) MustExecute(f func()) {
 	c.mux.Unlock()
 
 	if isHead {
-		c.g.Execute(func() {
-			i := 0
-			for {
+		c.execHead(f, false)
+	}
+}
+
+// execHead dispatches f, the head of execList, the jobs queued after it are run one by one in the same job.
+// if the head is dropped by the worker pool, only the head is removed, and the jobs queued by other
+// goroutines in the meantime are dispatched again with the next one as the head.
+func (c *Conn) execHead(f func(), recovered bool) {
+	for !c.g.execute(c, c.execLoop(f, recovered)) {
+		c.mux.Lock()
+		n := copy(c.execList, c.execList[1:])
+		c.execList[n] = nil
+		c.execList = c.execList[:n]
+		if n == 0 {
+			c.mux.Unlock()
+			return
+		}
+		f = c.execList[0]
+		c.mux.Unlock()
+	}
+}
+
+// execLoop returns the job that runs f and then the rest of execList, panics are recovered if recovered is true.
+func (c *Conn) execLoop(f func(), recovered bool) func() {
+	return func() {
+		i := 0
+		for {
+			if recovered {
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							const size = 64 << 10
+							buf := make([]byte, size)
+							buf = buf[:runtime.Stack(buf, false)]
+							logging.Error("conn execute failed: %v\n%v\n", err, *(*string)(unsafe.Pointer(&buf)))
+						}
+					}()
+					f()
+				}()
+			} else {
 				f()
+			}
 
-				c.mux.Lock()
-				i++
-				if len(c.execList) == i {
-					c.execList = c.execList[0:0]
-					c.mux.Unlock()
-					return
-				}
-				f = c.execList[i]
+			c.mux.Lock()
+			i++
+			if len(c.execList) == i {
+				c.execList = c.execList[0:0]
 				c.mux.Unlock()
+				return
 			}
-		})
+			f = c.execList[i]
+			c.mux.Unlock()
+		}
 	}
 }
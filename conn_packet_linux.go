@@ -0,0 +1,319 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package easyNet
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+const (
+	// packetBatchSize is the max datagrams read by one recvmmsg.
+	packetBatchSize = 16
+)
+
+// PacketConn is a udp socket served by a poller.
+type PacketConn struct {
+	mux sync.Mutex
+
+	g *Gopher
+	p *poller
+
+	fd     int
+	family int
+
+	closed   bool
+	isWAdded bool
+
+	lAddr net.Addr
+
+	// datagrams waiting for the kernel sendQ, and the sum of their length.
+	writeQueue []packet
+	writeLen   int
+
+	session interface{}
+}
+
+type packet struct {
+	to   syscall.Sockaddr
+	data []byte
+}
+
+// mmsghdr is struct mmsghdr used by recvmmsg.
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// packetBatch holds the poller's recvmmsg buffers, each of them is ReadBufferSize long.
+// a datagram longer than that is truncated by the kernel and dropped by readPackets.
+type packetBatch struct {
+	msgs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrAny
+	bufs  [][]byte
+}
+
+func newPacketBatch(bufferSize int) *packetBatch {
+	b := &packetBatch{
+		msgs:  make([]mmsghdr, packetBatchSize),
+		iovs:  make([]syscall.Iovec, packetBatchSize),
+		names: make([]syscall.RawSockaddrAny, packetBatchSize),
+		bufs:  make([][]byte, packetBatchSize),
+	}
+	for i := 0; i < packetBatchSize; i++ {
+		b.bufs[i] = make([]byte, bufferSize)
+		b.iovs[i].Base = &b.bufs[i][0]
+		b.iovs[i].SetLen(bufferSize)
+		b.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+		b.msgs[i].hdr.Iov = &b.iovs[i]
+		b.msgs[i].hdr.Iovlen = 1
+	}
+	return b
+}
+
+func isPacketNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp")
+}
+
+// Hash returns a hash code.
+func (c *PacketConn) Hash() int {
+	return c.fd
+}
+
+// LocalAddr returns the bound addr.
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.lAddr
+}
+
+// Session returns user session.
+func (c *PacketConn) Session() interface{} {
+	return c.session
+}
+
+// SetSession sets user session.
+func (c *PacketConn) SetSession(session interface{}) {
+	c.session = session
+}
+
+// WriteTo sends a datagram to addr, the datagram is queued if the kernel sendQ is full
+// and sent when the socket is writable again.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: syscall.EINVAL}
+	}
+	sa, err := udpAddrToSockaddr(c.family, udpAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return 0, errClosed
+	}
+
+	if len(c.writeQueue) == 0 {
+		err = syscall.Sendto(c.fd, b, 0, sa)
+		if err == nil {
+			return len(b), nil
+		}
+		if !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.EINTR) {
+			return 0, err
+		}
+	}
+
+	if c.g.maxWriteBufferSize > 0 && c.writeLen+len(b) > c.g.maxWriteBufferSize {
+		return 0, syscall.ENOBUFS
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.writeQueue = append(c.writeQueue, packet{to: sa, data: data})
+	c.writeLen += len(b)
+	if !c.isWAdded {
+		c.isWAdded = true
+		c.p.modWrite(c.fd)
+	}
+	return len(b), nil
+}
+
+// Close closes the socket.
+func (c *PacketConn) Close() error {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.writeQueue = nil
+	c.writeLen = 0
+	c.mux.Unlock()
+
+	if c.p != nil {
+		c.p.deleteEvent(c.fd)
+	}
+	return syscall.Close(c.fd)
+}
+
+func (c *PacketConn) flush() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return
+	}
+
+	for len(c.writeQueue) > 0 {
+		pkt := c.writeQueue[0]
+		err := syscall.Sendto(c.fd, pkt.data, 0, pkt.to)
+		if errors.Is(err, syscall.EAGAIN) {
+			return
+		}
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			logging.Error("[%v] sendto failed: %v", c.fd, err)
+		}
+		c.writeQueue[0] = packet{}
+		c.writeQueue = c.writeQueue[1:]
+		c.writeLen -= len(pkt.data)
+	}
+	c.writeQueue = nil
+	if c.isWAdded {
+		c.isWAdded = false
+		c.p.resetRead(c.fd)
+	}
+}
+
+func (c *PacketConn) writePending() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return !c.closed && len(c.writeQueue) > 0
+}
+
+func (p *poller) addPacketConn(c *PacketConn) error {
+	c.p = p
+	if p.packetBatch == nil {
+		p.packetBatch = newPacketBatch(p.g.readBufferSize)
+	}
+	return p.addRead(c.fd)
+}
+
+func (p *poller) getPacketConn(fd int) *PacketConn {
+	for _, c := range p.g.packetConns {
+		if c.fd == fd {
+			return c
+		}
+	}
+	return nil
+}
+
+func (p *poller) handlePacketEvent(c *PacketConn, events uint32) {
+	if events&syscall.EPOLLERR != 0 {
+		// a pending error such as ICMP unreachable is reported here, it must be cleared but the socket is still usable.
+		if err, _ := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR); err != 0 {
+			logging.Debug("[%v] udp socket error: %v", c.fd, syscall.Errno(err))
+		}
+	}
+
+	if events&epollEventsWrite != 0 {
+		c.flush()
+	}
+
+	if events&epollEventsRead != 0 {
+		p.readPackets(c)
+	}
+}
+
+func (p *poller) readPackets(c *PacketConn) {
+	b := p.packetBatch
+	et := p.isET()
+	for i := 0; et || i < p.g.maxReadTimesPerEventLoop; i++ {
+		for j := range b.msgs {
+			b.msgs[j].hdr.Namelen = syscall.SizeofSockaddrAny
+			b.msgs[j].hdr.Flags = 0
+			b.msgs[j].len = 0
+		}
+		r0, _, e0 := syscall.Syscall6(syscall.SYS_RECVMMSG, uintptr(c.fd), uintptr(unsafe.Pointer(&b.msgs[0])), uintptr(len(b.msgs)), syscall.MSG_DONTWAIT, 0, 0)
+		if e0 != 0 {
+			if e0 != syscall.EAGAIN && e0 != syscall.EINTR {
+				logging.Debug("[%v] recvmmsg failed: %v", c.fd, e0)
+			}
+			if e0 == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		n := int(r0)
+		for j := 0; j < n; j++ {
+			from := rawSockaddrToUDPAddr(&b.names[j])
+			if b.msgs[j].hdr.Flags&syscall.MSG_TRUNC != 0 {
+				// the rest of the datagram is discarded by the kernel, a partial datagram is not passed to OnPacket.
+				logging.Error("[%v] udp datagram from %v is longer than ReadBufferSize %v, dropped", c.fd, from, len(b.bufs[j]))
+				continue
+			}
+			p.g.onPacket(c, from, b.bufs[j][:b.msgs[j].len])
+		}
+		if n < len(b.msgs) && !et {
+			return
+		}
+	}
+}
+
+func newPacketConn(g *Gopher, addr string) (*PacketConn, error) {
+	fd, family, lAddr, err := g.adoptPacketConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	if fd < 0 {
+		fd, family, lAddr, err = listenPacket(g.network, addr, g.listenerNum > 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &PacketConn{
+		g:      g,
+		fd:     fd,
+		family: family,
+		lAddr:  lAddr,
+	}, nil
+}
+
+func udpAddrToSockaddr(family int, addr *net.UDPAddr) (syscall.Sockaddr, error) {
+	ip := addr.IP
+	if family == syscall.AF_INET6 && ip != nil && ip.To4() != nil {
+		// v4-mapped for a dual stack socket.
+		ip = ip.To16()
+	}
+	return addrToSockaddr(family, ip, addr.Port, addr.Zone)
+}
+
+func rawSockaddrToUDPAddr(rsa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: int(port[0])<<8 + int(port[1])}
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, pp.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: int(port[0])<<8 + int(port[1]), Zone: zoneToString(int(pp.Scope_id))}
+	}
+	return nil
+}
@@ -9,10 +9,12 @@ package easyNet
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +24,9 @@ type Conn struct {
 
 	hash int
 
+	// id is unique in a Gopher, unlike fd it's never reused.
+	id uint64
+
 	mux sync.Mutex
 
 	conn net.Conn
@@ -36,8 +41,13 @@ type Conn struct {
 
 	execList []func()
 
+	groups []*Group
+
 	cache *bytes.Buffer
 
+	// cacheLen is the length of cache published for Gopher.Stats.
+	cacheLen int64
+
 	DataHandler func(c *Conn, data []byte)
 }
 
@@ -57,6 +67,9 @@ func (c *Conn) Read(b []byte) (int, error) {
 		reader = c.cache
 	}
 	nread, err := reader.Read(b)
+	if c.cache != nil {
+		atomic.StoreInt64(&c.cacheLen, int64(c.cache.Len()))
+	}
 	if c.closeErr == nil {
 		c.closeErr = err
 	}
@@ -77,6 +90,9 @@ func (c *Conn) read(b []byte) (int, error) {
 			c.cache.Write(b[:nread])
 		}
 		c.g.onRead(c)
+		if c.cache != nil {
+			atomic.StoreInt64(&c.cacheLen, int64(c.cache.Len()))
+		}
 		return nread, nil
 	} else if nread > 0 {
 		c.g.onData(c, b[:nread])
@@ -100,6 +116,56 @@ func (c *Conn) Write(b []byte) (int, error) {
 	return nwrite, err
 }
 
+// WriteWithCallback writes b like Write, f is called once b is written since the write is blocking.
+func (c *Conn) WriteWithCallback(b []byte, f func(err error)) (int, error) {
+	defer c.g.onWriteBufferFree(c, b)
+
+	n, _, err := c.writeUnlessSlow(b, 0, f)
+	return n, err
+}
+
+// OnWriteDrained calls f at once, conns on this platform have no write buffer.
+func (c *Conn) OnWriteDrained(f func()) {
+	f()
+}
+
+// Flush returns at once, conns on this platform have no write buffer.
+func (c *Conn) Flush(ctx context.Context) error {
+	return nil
+}
+
+// writeUnlessSlow writes b, conns on this platform have no write buffer and never be slow.
+func (c *Conn) writeUnlessSlow(b []byte, threshold int, cb func(err error)) (int, bool, error) {
+	c.g.beforeWrite(c)
+
+	nwrite, err := c.conn.Write(b)
+	if err != nil {
+		if c.closeErr == nil {
+			c.closeErr = err
+		}
+		c.Close()
+	} else if cb != nil {
+		cb(nil)
+	}
+	return nwrite, false, err
+}
+
+func (c *Conn) getCodec() ICodec {
+	return nil
+}
+
+func (c *Conn) writeBufferLen() int {
+	return 0
+}
+
+func (c *Conn) writePending() bool {
+	return false
+}
+
+func (c *Conn) cacheBufferLen() int {
+	return int(atomic.LoadInt64(&c.cacheLen))
+}
+
 // Writev wraps buffers.WriteTo/syscall.Writev
 func (c *Conn) Writev(in [][]byte) (int, error) {
 	buffers := net.Buffers(in)
@@ -126,6 +192,7 @@ func (c *Conn) Close() error {
 		if c.g != nil {
 			c.g.pollers[c.Hash()%len(c.g.pollers)].deleteConn(c)
 		}
+		c.leaveGroups()
 		return err
 	}
 	c.mux.Unlock()
@@ -257,6 +324,10 @@ func newConn(conn net.Conn, fromClient ...interface{}) *Conn {
 	return c
 }
 
+func (c *Conn) atOnce(f func()) {
+	c.g.atOnce(f)
+}
+
 // NBConn converts net.Conn to *Conn
 func NBConn(conn net.Conn) (*Conn, error) {
 	if conn == nil {
@@ -0,0 +1,213 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	connTablePageBits = 12
+	connTablePageSize = 1 << connTablePageBits
+	connTablePageMask = connTablePageSize - 1
+)
+
+// connPage holds the conns of connTablePageSize fds, n is the number of conns in it.
+type connPage struct {
+	n     int32
+	conns [connTablePageSize]unsafe.Pointer
+}
+
+// connTable is a fd indexed table of conns, its memory grows with the max fd in use page by page,
+// the pages without conns are released. lookups and iterations are lock free, updates are serialized by mux.
+type connTable struct {
+	mux   sync.Mutex
+	count int64
+	pages atomic.Value // []*connPage
+}
+
+func newConnTable() *connTable {
+	t := &connTable{}
+	t.pages.Store([]*connPage{})
+	return t
+}
+
+func (t *connTable) get(fd int) *Conn {
+	pages := t.pages.Load().([]*connPage)
+	i := fd >> connTablePageBits
+	if fd < 0 || i >= len(pages) || pages[i] == nil {
+		return nil
+	}
+	return (*Conn)(atomic.LoadPointer(&pages[i].conns[fd&connTablePageMask]))
+}
+
+func (t *connTable) set(fd int, c *Conn) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	pages := t.pages.Load().([]*connPage)
+	i := fd >> connTablePageBits
+	if i >= len(pages) || pages[i] == nil {
+		// a loaded pages slice is never modified, readers always see whole pages.
+		size := len(pages)
+		if i >= size {
+			size = i + 1
+		}
+		newPages := make([]*connPage, size)
+		copy(newPages, pages)
+		newPages[i] = &connPage{}
+		pages = newPages
+		t.pages.Store(pages)
+	}
+	page := pages[i]
+
+	old := atomic.SwapPointer(&page.conns[fd&connTablePageMask], unsafe.Pointer(c))
+	if old == nil {
+		atomic.AddInt32(&page.n, 1)
+		t.count++
+	}
+}
+
+// delete removes c if it's still the conn of fd.
+func (t *connTable) delete(fd int, c *Conn) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	pages := t.pages.Load().([]*connPage)
+	i := fd >> connTablePageBits
+	if fd < 0 || i >= len(pages) || pages[i] == nil {
+		return false
+	}
+	page := pages[i]
+	if !atomic.CompareAndSwapPointer(&page.conns[fd&connTablePageMask], unsafe.Pointer(c), nil) {
+		return false
+	}
+	t.count--
+	// the first page is kept, or a server with a few conns allocates it on every accept.
+	if atomic.AddInt32(&page.n, -1) == 0 && i > 0 {
+		t.releasePage(pages, i)
+	}
+	return true
+}
+
+// releasePage stores a copy of pages without page i and the trailing nil pages.
+func (t *connTable) releasePage(pages []*connPage, i int) {
+	end := len(pages)
+	if i == end-1 {
+		end = i
+		for end > 0 && pages[end-1] == nil {
+			end--
+		}
+	}
+	newPages := make([]*connPage, end)
+	copy(newPages, pages)
+	if i < end {
+		newPages[i] = nil
+	}
+	t.pages.Store(newPages)
+}
+
+func (t *connTable) len() int {
+	if t == nil {
+		return 0
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return int(t.count)
+}
+
+// rangePages calls f for every conn in n pages from page start, it returns the page to start next time,
+// which is 0 after the last page.
+func (t *connTable) rangePages(start int, n int, f func(c *Conn)) int {
+	pages := t.pages.Load().([]*connPage)
+	if start >= len(pages) {
+		start = 0
+	}
+	end := start + n
+	if end > len(pages) {
+		end = len(pages)
+	}
+	for _, page := range pages[start:end] {
+		if page == nil || atomic.LoadInt32(&page.n) == 0 {
+			continue
+		}
+		for j := range page.conns {
+			if c := (*Conn)(atomic.LoadPointer(&page.conns[j])); c != nil {
+				f(c)
+			}
+		}
+	}
+	if end >= len(pages) {
+		return 0
+	}
+	return end
+}
+
+// rangeConns calls f for every conn until f returns false, the pages without conns are skipped.
+func (t *connTable) rangeConns(f func(c *Conn) bool) {
+	if t == nil {
+		return
+	}
+	pages := t.pages.Load().([]*connPage)
+	for _, page := range pages {
+		if page == nil || atomic.LoadInt32(&page.n) == 0 {
+			continue
+		}
+		for j := range page.conns {
+			c := (*Conn)(atomic.LoadPointer(&page.conns[j]))
+			if c != nil && !f(c) {
+				return
+			}
+		}
+	}
+}
+
+// connIDShardNum is the number of connIDMap shards, consecutive ids are in different shards.
+const connIDShardNum = 64
+
+// connIDMap maps conn ids to conns, it's sharded by id so that the pollers adding and deleting conns
+// at the same time rarely wait for each other.
+type connIDMap struct {
+	shards [connIDShardNum]connIDShard
+}
+
+type connIDShard struct {
+	mux   sync.Mutex
+	conns map[uint64]*Conn
+}
+
+func newConnIDMap() *connIDMap {
+	m := &connIDMap{}
+	for i := range m.shards {
+		m.shards[i].conns = map[uint64]*Conn{}
+	}
+	return m
+}
+
+func (m *connIDMap) get(id uint64) *Conn {
+	s := &m.shards[id%connIDShardNum]
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.conns[id]
+}
+
+func (m *connIDMap) set(id uint64, c *Conn) {
+	s := &m.shards[id%connIDShardNum]
+	s.mux.Lock()
+	s.conns[id] = c
+	s.mux.Unlock()
+}
+
+// delete removes c if it's still the conn of id.
+func (m *connIDMap) delete(id uint64, c *Conn) {
+	s := &m.shards[id%connIDShardNum]
+	s.mux.Lock()
+	if s.conns[id] == c {
+		delete(s.conns, id)
+	}
+	s.mux.Unlock()
+}
@@ -8,9 +8,11 @@
 package easyNet
 
 import (
+	"context"
 	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,39 +21,85 @@ import (
 
 // Conn implements net.Conn.
 type Conn struct {
+	// statistics, the 64-bit fields are kept at the beginning for atomic operations on 32-bit platforms.
+	// the read side is updated atomically by the poller, the write side is updated with mux held.
+	bytesRead       uint64
+	framesRead      uint64
+	lastRead        int64
+	bytesWritten    uint64
+	framesWritten   uint64
+	partialWrites   uint64
+	lastWrite       int64
+	openTime        int64
+	idleTimeout     int64
+	peakWriteBuffer int
+
 	mux sync.Mutex
 
 	g *Gopher
+	p *poller
 
 	fd int
 
+	// id is unique in a Gopher, unlike fd it's never reused.
+	id uint64
+
 	rTimer *htimer
 	wTimer *htimer
 
 	writeBuffer []byte
 
+	// sendFiles are the files queued by SendFile after writeBuffer, the data written meanwhile is queued as their tails.
+	sendFiles []*sendFileJob
+
+	// writeHigh is set when the write buffer reaches the high watermark and cleared at the low watermark,
+	// writeHighNotified is the state last reported to the handlers, it's used by the poller goroutine only.
+	writeHigh         bool
+	writeHighNotified bool
+
 	closed   bool
 	isWAdded bool
 	closeErr error
 
+	// readPaused is set by PauseRead with mux held, it's loaded atomically by the poller.
+	readPaused int32
+
 	lAddr net.Addr
 	rAddr net.Addr
 
+	// unix conns read with recvmsg to receive fds passed by SCM_RIGHTS.
+	isUnix bool
+	oob    []byte
+	rfds   []int
+
 	ReadBuffer []byte
 
 	session interface{}
 
-	chWaitWrite chan struct{}
+	// writeCallbacks are ordered by end, onDrained are called once writeBuffer becomes empty.
+	writeCallbacks []writeCallback
+	onDrained      []func()
 
 	execList []func()
 
+	groups []*Group
+
 	codec ICodec
 
+	// cacheLen is the length of CacheBuffer published by the poller for Gopher.Stats.
+	cacheLen int64
+
 	CacheBuffer []byte
 
 	DataHandler func(c *Conn, data []byte)
 }
 
+// writeCallback is called once bytesWritten reaches end.
+type writeCallback struct {
+	end uint64
+	f   func(err error)
+}
+
 // Hash returns a hash code.
 func (c *Conn) Hash() int {
 	return c.fd
@@ -67,22 +115,171 @@ func (c *Conn) Read(b []byte) (int, error) {
 	}
 	c.mux.Unlock()
 
-	n, err := syscall.Read(c.fd, b)
+	var n int
+	var err error
+	if c.isUnix {
+		n, err = c.readUnix(b)
+	} else {
+		n, err = syscall.Read(c.fd, b)
+	}
 	if err == nil {
 		c.g.afterRead(c)
 	}
+	if n > 0 {
+		atomic.AddUint64(&c.bytesRead, uint64(n))
+		atomic.StoreInt64(&c.lastRead, time.Now().UnixNano())
+		if c.p != nil {
+			atomic.AddUint64(&c.p.bytesRead, uint64(n))
+		}
+	}
+
+	return n, err
+}
 
+func (c *Conn) readUnix(b []byte) (int, error) {
+	if c.oob == nil {
+		c.oob = make([]byte, syscall.CmsgSpace(maxFdsPerMessage*4))
+	}
+	n, fds, err := recvmsg(c.fd, b, c.oob)
+	if len(fds) > 0 {
+		c.mux.Lock()
+		if c.closed {
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+		} else {
+			c.rfds = append(c.rfds, fds...)
+		}
+		c.mux.Unlock()
+	}
 	return n, err
 }
 
+// ReadFds returns the fds received with SCM_RIGHTS on a unix conn so far and clears them,
+// the caller owns the returned fds and should close them.
+func (c *Conn) ReadFds() []int {
+	c.mux.Lock()
+	fds := c.rfds
+	c.rfds = nil
+	c.mux.Unlock()
+	return fds
+}
+
+// WriteFds sends b with fds attached by SCM_RIGHTS on a unix conn, b must not be empty.
+// the fds are not cached, syscall.EAGAIN is returned if the conn has data waiting to be sent
+// or the kernel sendQ is full, the caller can retry later.
+func (c *Conn) WriteFds(b []byte, fds ...int) (int, error) {
+	if !c.isUnix {
+		return 0, errFdPassingUnsupported
+	}
+	if len(b) == 0 {
+		return 0, syscall.EINVAL
+	}
+
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return 0, errClosed
+	}
+	if c.hasPending() {
+		c.mux.Unlock()
+		return 0, syscall.EAGAIN
+	}
+
+	c.g.beforeWrite(c)
+
+	n, err := syscall.SendmsgN(c.fd, b, syscall.UnixRights(fds...), nil, 0)
+	if err != nil {
+		if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+			c.mux.Unlock()
+			return 0, syscall.EAGAIN
+		}
+		c.closed = true
+		c.mux.Unlock()
+		c.closeWithErrorWithoutLock(err)
+		return 0, err
+	}
+	c.onWritten(n)
+	// the fds are sent with the first byte, the rest is cached as normal data.
+	if n < len(b) {
+		c.write(b[n:])
+	}
+	c.onFrameWritten()
+	c.mux.Unlock()
+	return len(b), nil
+}
+
 // Write implements Write.
 func (c *Conn) Write(b []byte) (int, error) {
 	defer c.g.onWriteBufferFree(c, b)
 
+	n, _, err := c.writeUnlessSlow(b, 0, nil)
+	return n, err
+}
+
+// WriteWithCallback writes b like Write, f is called once b is written to the kernel,
+// or with the close error if the conn is closed before that. f is not called if an error is returned.
+func (c *Conn) WriteWithCallback(b []byte, f func(err error)) (int, error) {
+	defer c.g.onWriteBufferFree(c, b)
+
+	n, _, err := c.writeUnlessSlow(b, 0, f)
+	return n, err
+}
+
+// OnWriteDrained registers f to be called once when the write buffer becomes empty,
+// f is called at once if nothing is pending, and dropped if the conn is closed before that.
+func (c *Conn) OnWriteDrained(f func()) {
 	c.mux.Lock()
 	if c.closed {
 		c.mux.Unlock()
-		return -1, errClosed
+		return
+	}
+	if c.hasPending() {
+		c.onDrained = append(c.onDrained, f)
+		c.mux.Unlock()
+		return
+	}
+	c.mux.Unlock()
+	f()
+}
+
+// Flush blocks until the data written before is written to the kernel, the conn is closed, or ctx is done.
+func (c *Conn) Flush(ctx context.Context) error {
+	done := make(chan error, 1)
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return errClosed
+	}
+	if !c.hasPending() {
+		c.mux.Unlock()
+		return nil
+	}
+	c.addWriteCallback(func(err error) {
+		done <- err
+	})
+	c.mux.Unlock()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeUnlessSlow writes b unless the pending write buffer is larger than threshold, 0 means no limit.
+// b is not retained or passed to onWriteBufferFree, so it can be shared by conns.
+// cb is called as WriteWithCallback if it's not nil.
+func (c *Conn) writeUnlessSlow(b []byte, threshold int, cb func(err error)) (int, bool, error) {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return -1, false, errClosed
+	}
+	if threshold > 0 && c.bufferedLen() > threshold {
+		c.mux.Unlock()
+		return 0, true, nil
 	}
 
 	c.g.beforeWrite(c)
@@ -92,10 +289,11 @@ func (c *Conn) Write(b []byte) (int, error) {
 		c.closed = true
 		c.mux.Unlock()
 		c.closeWithErrorWithoutLock(err)
-		return n, err
+		return n, false, err
 	}
+	c.onFrameWritten()
 
-	if len(c.writeBuffer) == 0 {
+	if !c.hasPending() {
 		if c.wTimer != nil {
 			c.wTimer.Stop()
 			c.wTimer = nil
@@ -103,9 +301,19 @@ func (c *Conn) Write(b []byte) (int, error) {
 	} else {
 		c.modWrite()
 	}
+	high := c.reachHighWatermark()
+	if cb != nil && !c.addWriteCallback(cb) {
+		cb = nil
+	}
 
 	c.mux.Unlock()
-	return n, err
+	if high {
+		c.atOnce(c.notifyWatermark)
+	}
+	if cb != nil {
+		cb(nil)
+	}
+	return n, false, err
 }
 
 // Writev implements Writev.
@@ -138,7 +346,8 @@ func (c *Conn) Writev(in [][]byte) (int, error) {
 		c.closeWithErrorWithoutLock(err)
 		return n, err
 	}
-	if len(c.writeBuffer) == 0 {
+	c.onFrameWritten()
+	if !c.hasPending() {
 		if c.wTimer != nil {
 			c.wTimer.Stop()
 			c.wTimer = nil
@@ -146,8 +355,12 @@ func (c *Conn) Writev(in [][]byte) (int, error) {
 	} else {
 		c.modWrite()
 	}
+	high := c.reachHighWatermark()
 
 	c.mux.Unlock()
+	if high {
+		c.atOnce(c.notifyWatermark)
+	}
 	return n, err
 }
 
@@ -178,12 +391,12 @@ func (c *Conn) SetDeadline(t time.Time) error {
 		if !t.IsZero() {
 			now := time.Now()
 			if c.rTimer == nil {
-				c.rTimer = c.g.afterFunc(t.Sub(now), func() { c.closeWithError(errReadTimeout) })
+				c.rTimer = c.afterFunc(t.Sub(now), func() { c.closeWithError(errReadTimeout) })
 			} else {
 				c.rTimer.Reset(t.Sub(now))
 			}
 			if c.wTimer == nil {
-				c.wTimer = c.g.afterFunc(t.Sub(now), func() { c.closeWithError(errWriteTimeout) })
+				c.wTimer = c.afterFunc(t.Sub(now), func() { c.closeWithError(errWriteTimeout) })
 			} else {
 				c.wTimer.Reset(t.Sub(now))
 			}
@@ -202,6 +415,23 @@ func (c *Conn) SetDeadline(t time.Time) error {
 	return nil
 }
 
+// afterFunc adds a timer fired by the poller that owns c, or by the Gopher if c is not added yet.
+func (c *Conn) afterFunc(timeout time.Duration, f func()) *htimer {
+	if c.p != nil {
+		return c.p.afterFunc(timeout, f)
+	}
+	return c.g.afterFunc(timeout, f)
+}
+
+// atOnce queues f to be called by the poller that owns c, or by the Gopher if c is not added yet.
+func (c *Conn) atOnce(f func()) {
+	if c.p != nil {
+		c.p.atOnce(f)
+		return
+	}
+	c.g.atOnce(f)
+}
+
 func (c *Conn) setDeadline(timer **htimer, returnErr error, t time.Time) error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -211,7 +441,7 @@ func (c *Conn) setDeadline(timer **htimer, returnErr error, t time.Time) error {
 	if !t.IsZero() {
 		now := time.Now()
 		if *timer == nil {
-			*timer = c.g.afterFunc(t.Sub(now), func() { c.closeWithError(returnErr) })
+			*timer = c.afterFunc(t.Sub(now), func() { c.closeWithError(returnErr) })
 		} else {
 			(*timer).Reset(t.Sub(now))
 		}
@@ -290,17 +520,68 @@ func (c *Conn) SetSession(session interface{}) {
 func (c *Conn) modWrite() {
 	if !c.closed && !c.isWAdded {
 		c.isWAdded = true
-		c.g.pollers[c.Hash()%len(c.g.pollers)].modWrite(c.fd)
+		if c.isReadPaused() {
+			c.p.modConnEvents(c.fd, false, true)
+		} else {
+			c.p.modWrite(c.fd)
+		}
 	}
 }
 
 func (c *Conn) resetRead() {
 	if !c.closed && c.isWAdded {
 		c.isWAdded = false
-		p := c.g.pollers[c.Hash()%len(c.g.pollers)]
-		p.deleteEvent(c.fd)
-		p.addRead(c.fd)
+		if c.isReadPaused() {
+			c.p.modConnEvents(c.fd, false, false)
+		} else {
+			c.p.resetRead(c.fd)
+		}
+	}
+}
+
+// PauseRead stops reading from the socket until ResumeRead is called, the data received meanwhile is left
+// in the kernel recvQ so that the peer is slowed down by tcp flow control.
+// it can be called by handlers and Execute jobs, the jobs queued before are still executed.
+func (c *Conn) PauseRead() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return errClosed
+	}
+	if c.isReadPaused() {
+		return nil
+	}
+	atomic.StoreInt32(&c.readPaused, 1)
+	if c.p == nil {
+		return nil
 	}
+	return c.p.modConnEvents(c.fd, false, c.isWAdded)
+}
+
+// ResumeRead restores reading stopped by PauseRead.
+func (c *Conn) ResumeRead() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return errClosed
+	}
+	if !c.isReadPaused() {
+		return nil
+	}
+	atomic.StoreInt32(&c.readPaused, 0)
+	if c.p == nil {
+		return nil
+	}
+	return c.p.modConnEvents(c.fd, true, c.isWAdded)
+}
+
+// IsReadPaused returns true if reading is stopped by PauseRead.
+func (c *Conn) IsReadPaused() bool {
+	return c.isReadPaused()
+}
+
+func (c *Conn) isReadPaused() bool {
+	return atomic.LoadInt32(&c.readPaused) != 0
 }
 
 func (c *Conn) write(b []byte) (int, error) {
@@ -311,7 +592,7 @@ func (c *Conn) write(b []byte) (int, error) {
 		return -1, syscall.EINVAL
 	}
 
-	if len(c.writeBuffer) == 0 {
+	if !c.hasPending() {
 		n, err := syscall.Write(c.fd, b)
 		if err != nil && !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.EAGAIN) {
 			return n, err
@@ -319,19 +600,75 @@ func (c *Conn) write(b []byte) (int, error) {
 		if n < 0 {
 			n = 0
 		}
+		c.onWritten(n)
 		left := len(b) - n
 		if left > 0 {
+			c.partialWrites++
 			c.writeBuffer = mempool.Malloc(left)
 			copy(c.writeBuffer, b[n:])
 			c.modWrite()
 		}
 		return len(b), nil
 	}
-	c.writeBuffer = append(c.writeBuffer, b...)
+	c.appendWriteBuffer(b)
 
 	return len(b), nil
 }
 
+// appendWriteBuffer queues b after the pending data, it should be called with c.mux held.
+func (c *Conn) appendWriteBuffer(b []byte) {
+	if n := len(c.sendFiles); n > 0 {
+		job := c.sendFiles[n-1]
+		job.tail = append(job.tail, b...)
+		return
+	}
+	c.writeBuffer = append(c.writeBuffer, b...)
+}
+
+// hasPending returns true if there's data or file waiting to be sent, it should be called with c.mux held.
+func (c *Conn) hasPending() bool {
+	return len(c.writeBuffer) > 0 || len(c.sendFiles) > 0
+}
+
+// bufferedLen returns the bytes copied and waiting to be sent, the queued files are not counted.
+// it should be called with c.mux held.
+func (c *Conn) bufferedLen() int {
+	n := len(c.writeBuffer)
+	for _, job := range c.sendFiles {
+		n += len(job.tail)
+	}
+	return n
+}
+
+// pendingLen returns all the bytes waiting to be sent, it should be called with c.mux held.
+func (c *Conn) pendingLen() uint64 {
+	n := uint64(len(c.writeBuffer))
+	for _, job := range c.sendFiles {
+		n += uint64(job.remain) + uint64(len(job.tail))
+	}
+	return n
+}
+
+// onWritten and onFrameWritten should be called with c.mux held.
+func (c *Conn) onWritten(n int) {
+	if n <= 0 {
+		return
+	}
+	c.bytesWritten += uint64(n)
+	// lastWrite is loaded by the idle sweeper without mux.
+	atomic.StoreInt64(&c.lastWrite, time.Now().UnixNano())
+	if c.p != nil {
+		atomic.AddUint64(&c.p.bytesWritten, uint64(n))
+	}
+}
+
+func (c *Conn) onFrameWritten() {
+	c.framesWritten++
+	if n := c.bufferedLen(); n > c.peakWriteBuffer {
+		c.peakWriteBuffer = n
+	}
+}
+
 func (c *Conn) flush() error {
 	c.mux.Lock()
 	if c.closed {
@@ -339,48 +676,150 @@ func (c *Conn) flush() error {
 		return errClosed
 	}
 
-	if len(c.writeBuffer) == 0 {
+	if !c.hasPending() {
 		c.mux.Unlock()
 		return nil
 	}
 
-	old := c.writeBuffer
+	// in EPOLLET mod, write until EAGAIN, otherwise no more writing event would be reported.
+	et := c.p.isET()
+	for c.hasPending() {
+		var complete bool
+		var err error
+		if len(c.writeBuffer) > 0 {
+			complete, err = c.flushWriteBuffer()
+		} else {
+			complete, err = c.flushSendFile()
+		}
+		if err != nil && !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.EAGAIN) {
+			c.closed = true
+			c.mux.Unlock()
+			c.closeWithErrorWithoutLock(err)
+			return err
+		}
+		if !complete && (!et || errors.Is(err, syscall.EAGAIN)) {
+			break
+		}
+	}
+	if !c.hasPending() {
+		if c.wTimer != nil {
+			c.wTimer.Stop()
+			c.wTimer = nil
+		}
+		c.resetRead()
+	}
+	low := c.reachLowWatermark()
+	callbacks := c.takeWriteCallbacks()
+	var drained []func()
+	if !c.hasPending() {
+		drained = c.onDrained
+		c.onDrained = nil
+	}
+
+	c.mux.Unlock()
+	if low {
+		c.atOnce(c.notifyWatermark)
+	}
+	for _, cb := range callbacks {
+		cb.f(nil)
+	}
+	for _, f := range drained {
+		f()
+	}
+	return nil
+}
 
+// flushWriteBuffer writes writeBuffer once, it returns true if writeBuffer is fully written.
+// it should be called with c.mux held.
+func (c *Conn) flushWriteBuffer() (bool, error) {
+	old := c.writeBuffer
 	n, err := syscall.Write(c.fd, old)
 	if err != nil && !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.EAGAIN) {
-		c.closed = true
-		c.mux.Unlock()
-		c.closeWithErrorWithoutLock(err)
-		return err
+		return false, err
 	}
 	if n < 0 {
 		n = 0
 	}
+	c.onWritten(n)
 	left := len(old) - n
 	if left > 0 {
+		c.partialWrites++
 		if n > 0 {
 			c.writeBuffer = mempool.Malloc(left)
 			copy(c.writeBuffer, old[n:])
 			mempool.Free(old)
 		}
-		// c.modWrite()
+		return false, err
+	}
+	c.writeBuffer = nil
+	return true, nil
+}
+
+// addWriteCallback queues f until the pending bytes are written, it returns true if nothing is pending
+// and f should be called by the caller. it should be called with c.mux held.
+func (c *Conn) addWriteCallback(f func(err error)) bool {
+	if !c.hasPending() {
+		return true
+	}
+	c.writeCallbacks = append(c.writeCallbacks, writeCallback{
+		end: c.bytesWritten + c.pendingLen(),
+		f:   f,
+	})
+	return false
+}
+
+// takeWriteCallbacks removes and returns the callbacks whose bytes are written, it should be called with c.mux held.
+func (c *Conn) takeWriteCallbacks() []writeCallback {
+	i := 0
+	for i < len(c.writeCallbacks) && c.writeCallbacks[i].end <= c.bytesWritten {
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	callbacks := c.writeCallbacks[:i]
+	if i == len(c.writeCallbacks) {
+		c.writeCallbacks = nil
 	} else {
-		c.writeBuffer = nil
-		if c.wTimer != nil {
-			c.wTimer.Stop()
-			c.wTimer = nil
-		}
-		c.resetRead()
-		if c.chWaitWrite != nil {
-			select {
-			case c.chWaitWrite <- struct{}{}:
-			default:
-			}
-		}
+		c.writeCallbacks = c.writeCallbacks[i:]
+	}
+	return callbacks
+}
+
+// reachHighWatermark and reachLowWatermark return true if writeHigh is changed, they should be called with c.mux held.
+func (c *Conn) reachHighWatermark() bool {
+	high := c.g.writeHighWatermark
+	if high <= 0 || c.writeHigh || c.bufferedLen() < high {
+		return false
+	}
+	c.writeHigh = true
+	return true
+}
+
+func (c *Conn) reachLowWatermark() bool {
+	if !c.writeHigh || c.bufferedLen() > c.g.writeLowWatermark {
+		return false
 	}
+	c.writeHigh = false
+	return true
+}
 
+// notifyWatermark reports the current watermark state if it's changed since the last report,
+// so that OnWriteBufferHigh and OnWriteBufferLow are called alternately even if the changes are queued out of order.
+func (c *Conn) notifyWatermark() {
+	c.mux.Lock()
+	high := c.writeHigh
+	closed := c.closed
 	c.mux.Unlock()
-	return nil
+	if closed || high == c.writeHighNotified {
+		return
+	}
+	c.writeHighNotified = high
+	if high {
+		c.g.onWriteBufferHigh(c)
+	} else {
+		c.g.onWriteBufferLow(c)
+	}
 }
 
 func (c *Conn) writev(in [][]byte) (int, error) {
@@ -391,9 +830,9 @@ func (c *Conn) writev(in [][]byte) (int, error) {
 	if c.overflow(size) {
 		return -1, syscall.EINVAL
 	}
-	if len(c.writeBuffer) > 0 {
+	if c.hasPending() {
 		for _, v := range in {
-			c.writeBuffer = append(c.writeBuffer, v...)
+			c.appendWriteBuffer(v)
 		}
 		return size, nil
 	}
@@ -422,7 +861,7 @@ func (c *Conn) writev(in [][]byte) (int, error) {
 }
 
 func (c *Conn) overflow(n int) bool {
-	return c.g.maxWriteBufferSize > 0 && (len(c.writeBuffer)+n > c.g.maxWriteBufferSize)
+	return c.g.maxWriteBufferSize > 0 && (c.bufferedLen()+n > c.g.maxWriteBufferSize)
 }
 
 func (c *Conn) closeWithError(err error) error {
@@ -450,25 +889,77 @@ func (c *Conn) closeWithErrorWithoutLock(err error) error {
 
 	mempool.Free(c.writeBuffer)
 	c.writeBuffer = nil
+	c.closeSendFiles()
 
-	if c.chWaitWrite != nil {
-		select {
-		case c.chWaitWrite <- struct{}{}:
-		default:
-		}
+	for _, fd := range c.rfds {
+		syscall.Close(fd)
+	}
+	c.rfds = nil
+
+	callbacks := c.writeCallbacks
+	c.writeCallbacks = nil
+	c.onDrained = nil
+
+	if c.p != nil {
+		c.p.deleteConn(c)
 	}
 
-	if c.g != nil {
-		c.g.pollers[c.Hash()%len(c.g.pollers)].deleteConn(c)
+	c.leaveGroups()
+
+	closeErr := syscall.Close(c.fd)
+
+	if len(callbacks) > 0 {
+		if err == nil {
+			err = errClosed
+		}
+		for _, cb := range callbacks {
+			cb.f(err)
+		}
 	}
 
-	return syscall.Close(c.fd)
+	return closeErr
 }
 
 func (c *Conn) SetCodec(codec ICodec) {
 	c.codec = codec
 }
 
+func (c *Conn) getCodec() ICodec {
+	return c.codec
+}
+
+// Stats returns the traffic and activity statistics of the conn.
+func (c *Conn) Stats() ConnStats {
+	st := ConnStats{
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		FramesRead:   atomic.LoadUint64(&c.framesRead),
+		OpenTime:     unixNanoToTime(atomic.LoadInt64(&c.openTime)),
+		LastReadTime: unixNanoToTime(atomic.LoadInt64(&c.lastRead)),
+	}
+	c.mux.Lock()
+	st.BytesWritten = c.bytesWritten
+	st.FramesWritten = c.framesWritten
+	st.PartialWrites = c.partialWrites
+	st.WriteBufferLen = c.bufferedLen()
+	st.PeakWriteBufferLen = c.peakWriteBuffer
+	st.LastWriteTime = unixNanoToTime(atomic.LoadInt64(&c.lastWrite))
+	c.mux.Unlock()
+	return st
+}
+
+// writeBufferLen should be called with c.mux held.
+func (c *Conn) writeBufferLen() int {
+	return c.bufferedLen()
+}
+
+func (c *Conn) writePending() bool {
+	return c.hasPending()
+}
+
+func (c *Conn) cacheBufferLen() int {
+	return int(atomic.LoadInt64(&c.cacheLen))
+}
+
 // NBConn converts net.Conn to *Conn.
 func NBConn(conn net.Conn) (*Conn, error) {
 	if conn == nil {
@@ -494,7 +985,9 @@ func (c *Conn) handlerProtocol(buf []byte) {
 	*cache = append(*cache, buf...)
 	frame, err := c.codec.Decode(c)
 	for err == nil && len(frame) != 0 {
+		atomic.AddUint64(&c.framesRead, 1)
 		c.g.onData(c, frame)
 		frame, err = c.codec.Decode(c)
 	}
+	atomic.StoreInt64(&c.cacheLen, int64(len(*cache)))
 }
@@ -16,4 +16,15 @@ var (
 	errUnexpectedEOF      = errors.New("unexpected EOF error")
 	errTooLessLength      = errors.New("too less length")
 	errUnsupportedLength  = errors.New("unsupported length")
+
+	errFdPassingUnsupported = errors.New("fd passing is supported by unix conns only")
+	errWorkerQueueFull      = errors.New("worker queue full")
+	errNoListener           = errors.New("no listener")
+	errSlowConsumer         = errors.New("slow consumer")
+	errUnixgramUnsupported  = errors.New("unixgram is not supported, use unix or unixpacket")
+)
+
+var (
+	// ErrIdleTimeout is passed to OnClose for conns closed by Config.IdleTimeout or Conn.SetIdleTimeout.
+	ErrIdleTimeout = errors.New("idle timeout")
 )
@@ -5,10 +5,11 @@
 package easyNet
 
 import (
-	"container/heap"
+	"context"
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -27,10 +28,15 @@ const (
 
 	// DefaultMinConnCacheSize .
 	DefaultMinConnCacheSize = 1024 * 2
+
+	// shutdownPollInterval is the interval Shutdown checks whether the conns are drained.
+	shutdownPollInterval = time.Millisecond * 10
 )
 
 var (
 	// MaxOpenFiles .
+	//
+	// Deprecated: conns are kept by a table growing with the max fd in use, it's not used any more.
 	MaxOpenFiles = 1024 * 1024
 )
 
@@ -40,8 +46,8 @@ type Config struct {
 	Name string
 
 	// Network is the listening protocol, used with Addrs toghter.
-	// tcp* supported only by now, there's no plan for other protocol such as udp,
-	// because it's too easy to write udp server/client.
+	// tcp*, udp*, unix and unixpacket supported by now, datagrams of udp* are handled by OnPacket.
+	// a unix addr beginning with '@' is in the linux abstract namespace.
 	Network string
 
 	// Addrs is the listening addr list for a easyNet server.
@@ -51,13 +57,17 @@ type Config struct {
 	// NPoller represents poller goroutine num, it's set to runtime.NumCPU() by default.
 	NPoller int
 
-	// NListener represents poller goroutine num, it's set to runtime.NumCPU() by default.
+	// NListener represents listener goroutine num for each addr in Addrs, it's set to 1 by default.
+	// if it is greater than 1, each listener is bound with SO_REUSEPORT and the kernel spreads
+	// incoming connections among them.
 	NListener int
 
 	// Backlog represents backlog arg for syscall.Listen
 	Backlog int
 
 	// ReadBufferSize represents buffer size for reading, it's set to 16k by default.
+	// it's also the max size of a udp* datagram, each datagram read by recvmmsg gets a buffer of this size,
+	// and a longer one is dropped instead of being passed to OnPacket truncated.
 	ReadBufferSize int
 
 	// MinConnCacheSize represents application layer's Conn write cache buffer size when the kernel sendQ is full
@@ -66,19 +76,73 @@ type Config struct {
 	// MaxWriteBufferSize represents max write buffer size for Conn, it's set to 1m by default.
 	// if the connection's Send-Q is full and the data cached by easyNet is
 	// more than MaxWriteBufferSize, the connection would be closed by easyNet.
+	// see WriteBufferHighWatermark for a softer backpressure.
 	MaxWriteBufferSize int
 
+	// WriteBufferHighWatermark is the pending write bytes of a Conn that triggers OnWriteBufferHigh, 0 disables the watermarks.
+	// it's a softer backpressure than MaxWriteBufferSize, which should be left larger as the last resort,
+	// it's set to half of MaxWriteBufferSize if it's not less than that.
+	WriteBufferHighWatermark int
+
+	// WriteBufferLowWatermark is the pending write bytes that triggers OnWriteBufferLow after OnWriteBufferHigh,
+	// it's set to half of WriteBufferHighWatermark by default.
+	WriteBufferLowWatermark int
+
 	// MaxReadTimesPerEventLoop represents max read times in one poller loop for one fd
 	MaxReadTimesPerEventLoop int
 
+	// AcceptInPoller represents whether listening fds are registered in every poller with EPOLLEXCLUSIVE
+	// and accepted inside the poller loop, instead of by blocking listener goroutines, it's set to false by default.
+	// a new connection is served by the poller that accepted it, NListener still applies to SO_REUSEPORT sharding.
+	AcceptInPoller bool
+
+	// IdleTimeout closes the conns without reading or writing for that long with ErrIdleTimeout,
+	// it's disabled if it is 0 and can be overridden by Conn.SetIdleTimeout.
+	// conns are checked incrementally, so a conn may be closed up to a few seconds later than the timeout.
+	IdleTimeout time.Duration
+
+	// TimerEngine selects the engine of timers and deadlines, TimerEngineHeap by default.
+	TimerEngine int
+
+	// TimerTick is the granularity of TimerEngineWheel, it's set to 1ms by default.
+	TimerTick time.Duration
+
+	// ListenerNames maps the socket names in LISTEN_FDNAMES of systemd socket activation to Addrs,
+	// the sockets without a name in it are matched to Addrs by their local addrs.
+	// stream sockets are adopted by Gophers of tcp*, unix and unixpacket networks, datagram sockets by udp* ones.
+	// the sockets are shared by the Gophers of the process, the ones not adopted by any Gopher are kept open
+	// until CloseInheritedListeners is called. the unix socket files of adopted sockets are never removed.
+	ListenerNames map[string]string
+
+	// NWorker represents the goroutine num of the built-in worker pool, no worker pool is created if it is 0.
+	// if it is greater than 0, Gopher.Execute and Conn.Execute run jobs on the worker pool instead of the poller goroutine.
+	NWorker int
+
+	// WorkerQueueSize represents the max jobs waiting for a free worker, it's set to 1024 by default.
+	WorkerQueueSize int
+
+	// WorkerOverflow represents the policy when the worker queue is full, WorkerOverflowBlock by default.
+	// notice that WorkerOverflowBlock blocks the poller goroutine if the job is queued by it.
+	WorkerOverflow int
+
 	// LockListener represents listener's goroutine to lock thread or not, it's set to false by default.
 	LockListener bool
 
 	// LockPoller represents poller's goroutine to lock thread or not, it's set to false by default.
 	LockPoller bool
 
+	// Balancer picks the poller for a new conn accepted by listeners or added by AddConn,
+	// conns are spread by fd if it is nil.
+	Balancer Balancer
+
 	// EpollMod sets the epoll mod, EPOLLLT by default.
+	// in EPOLLET mod, every read event is handled by reading until EAGAIN, and write interest
+	// is registered once with read interest. it's ignored by IOModUring.
 	EpollMod int
+
+	// IOMod sets the event engine used by pollers, IOModEpoll by default.
+	// IOModUring batches poll submissions and completions through io_uring, linux 5.1+ only.
+	IOMod int
 }
 
 // Gopher is a manager of poller.
@@ -92,19 +156,44 @@ type Gopher struct {
 	network                  string
 	addrs                    []string
 	pollerNum                int
+	listenerNum              int
 	backlogSize              int
 	readBufferSize           int
 	maxWriteBufferSize       int
+	writeHighWatermark       int
+	writeLowWatermark        int
 	maxReadTimesPerEventLoop int
 	minConnCacheSize         int
 	epollMod                 int
+	ioMod                    int
+	acceptInPoller           bool
+	balancer                 Balancer
 	lockListener             bool
 	lockPoller               bool
 
 	lfds []int
 
+	// passed is set if the listener fds are passed to a child.
+	passed        bool
+	listenerNames map[string]string
+
 	connsStd  map[*Conn]struct{}
-	connsUnix []*Conn
+	connsUnix *connTable
+
+	connsAccepted uint64
+	connsClosed   uint64
+
+	idleTimeout  time.Duration
+	idleSweeping int32
+	idleCursor   int
+
+	// connID is the last conn id.
+	connID    uint64
+	connsByID *connIDMap
+
+	groups map[string]*Group
+
+	packetConns []*PacketConn
 
 	listeners []*poller
 	pollers   []*poller
@@ -113,64 +202,153 @@ type Gopher struct {
 	onClose           func(c *Conn, err error)
 	onRead            func(c *Conn)
 	onData            func(c *Conn, data []byte)
+	onPacket          func(c *PacketConn, from net.Addr, data []byte)
 	onReadBufferAlloc func(c *Conn) []byte
 	onReadBufferFree  func(c *Conn, buffer []byte)
 	onWriteBufferFree func(c *Conn, buffer []byte)
+	onWriteBufferHigh func(c *Conn)
+	onWriteBufferLow  func(c *Conn)
 	beforeRead        func(c *Conn)
 	afterRead         func(c *Conn)
 	beforeWrite       func(c *Conn)
 	onStop            func()
+	onShutdown        func()
+
+	stopOnce          sync.Once
+	stopListenersOnce sync.Once
+
+	workers *workerPool
 
-	callings  []func()
-	chCalling chan struct{}
-	timers    timerHeap
-	trigger   *time.Timer
-	chTimer   chan struct{}
+	callings    []func()
+	chCalling   chan struct{}
+	timers      timerQueue
+	timerEngine int
+	timerTick   time.Duration
+	triggerAt   time.Time
+	trigger     *time.Timer
+	chTimer     chan struct{}
 
 	Execute func(f func())
 }
 
 // Stop pollers.
 func (g *Gopher) Stop() {
+	g.stopOnce.Do(g.stop)
+}
+
+func (g *Gopher) stop() {
 	g.onStop()
 
-	g.trigger.Stop()
-	close(g.chTimer)
+	g.stopListeners()
 
-	for _, l := range g.listeners {
-		l.stop()
-	}
-	for i := 0; i < g.pollerNum; i++ {
-		g.pollers[i].stop()
-	}
 	g.mux.Lock()
 	conns := g.connsStd
 	g.connsStd = map[*Conn]struct{}{}
-	connsUnix := g.connsUnix
 	g.mux.Unlock()
+	connsUnix := make([]*Conn, 0, g.connsUnix.len())
+	g.connsUnix.rangeConns(func(c *Conn) bool {
+		connsUnix = append(connsUnix, c)
+		return true
+	})
 
 	for c := range conns {
 		if c != nil {
 			c.Close()
 		}
 	}
+	// conns are closed before pollers are stopped, their events are deleted while the pollers are still alive.
 	for _, c := range connsUnix {
-		if c != nil {
-			go c.Close()
-		}
+		c.Close()
+	}
+	for _, c := range g.packetConns {
+		c.Close()
+	}
+
+	g.stopIdleSweep()
+	g.trigger.Stop()
+	close(g.chTimer)
+
+	for i := 0; i < g.pollerNum; i++ {
+		g.pollers[i].stop()
+	}
+
+	if g.workers != nil {
+		g.workers.stop()
 	}
 
 	g.Wait()
+	g.closePollerListenFds()
 	logging.Info("Gopher[%v] stop", g.Name)
 }
 
+// Shutdown stops Gopher gracefully: it stops accepting, calls the OnShutdown handler, and waits for
+// write buffers to be flushed and Conn.Execute jobs to be done before stopping.
+// if ctx is done before that, the conns are closed by force and ctx.Err() is returned.
+func (g *Gopher) Shutdown(ctx context.Context) error {
+	g.stopListeners()
+	g.onShutdown()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !g.drained() {
+		select {
+		case <-ctx.Done():
+			g.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	g.Stop()
+	return nil
+}
+
+func (g *Gopher) stopListeners() {
+	g.stopListenersOnce.Do(func() {
+		for _, l := range g.listeners {
+			if l != nil {
+				l.stop()
+			}
+		}
+	})
+}
+
+// drained returns true if there's no data waiting to be written and no job waiting to be executed.
+func (g *Gopher) drained() bool {
+	if g.workers != nil {
+		if stats := g.workers.stats(); stats.Busy > 0 || stats.QueueLen > 0 {
+			return false
+		}
+	}
+
+	g.mux.Lock()
+	conns := make([]*Conn, 0, len(g.connsStd))
+	for c := range g.connsStd {
+		conns = append(conns, c)
+	}
+	g.mux.Unlock()
+	g.connsUnix.rangeConns(func(c *Conn) bool {
+		conns = append(conns, c)
+		return true
+	})
+
+	for _, c := range conns {
+		c.mux.Lock()
+		pending := !c.closed && (c.writePending() || len(c.execList) > 0)
+		c.mux.Unlock()
+		if pending {
+			return false
+		}
+	}
+	return true
+}
+
 // AddConn adds conn to a poller.
 func (g *Gopher) AddConn(conn net.Conn) (*Conn, error) {
 	c, err := NBConn(conn)
 	if err != nil {
 		return nil, err
 	}
-	g.pollers[uint32(c.Hash())%uint32(g.pollerNum)].addConn(c)
+	g.pickPoller(c).addConn(c)
 	return c, nil
 }
 
@@ -182,19 +360,21 @@ func (g *Gopher) OnOpen(h func(c *Conn)) {
 	g.onOpen = h
 }
 
-// OnClose registers callback for disconnected.
+// OnClose registers callback for disconnected, it is called by the poller that owns the conn after the current event is handled.
 func (g *Gopher) OnClose(h func(c *Conn, err error)) {
 	if h == nil {
 		panic("invalid nil handler")
 	}
 	g.onClose = func(c *Conn, err error) {
-		g.atOnce(func() {
+		c.atOnce(func() {
 			h(c, err)
 		})
 	}
 }
 
-// OnRead registers callback for reading event.
+// OnRead registers callback for reading event, it replaces the built-in reading and OnData.
+// in EPOLLET mod, the handler must call c.Read until it returns syscall.EAGAIN,
+// otherwise no more reading event would be reported for the data left in the socket.
 func (g *Gopher) OnRead(h func(c *Conn)) {
 	g.onRead = h
 }
@@ -207,6 +387,14 @@ func (g *Gopher) OnData(h func(c *Conn, data []byte)) {
 	g.onData = h
 }
 
+// OnPacket registers callback for datagrams of udp* networks.
+func (g *Gopher) OnPacket(h func(c *PacketConn, from net.Addr, data []byte)) {
+	if h == nil {
+		panic("invalid nil handler")
+	}
+	g.onPacket = h
+}
+
 // OnReadBufferAlloc registers callback for memory allocating.
 func (g *Gopher) OnReadBufferAlloc(h func(c *Conn) []byte) {
 	if h == nil {
@@ -231,6 +419,25 @@ func (g *Gopher) OnWriteBufferRelease(h func(c *Conn, b []byte)) {
 	g.onWriteBufferFree = h
 }
 
+// OnWriteBufferHigh registers callback for the pending write bytes of a conn reaching WriteBufferHighWatermark,
+// producers should pause writing until OnWriteBufferLow is called.
+// the callbacks are called in order by the poller that owns the conn.
+func (g *Gopher) OnWriteBufferHigh(h func(c *Conn)) {
+	if h == nil {
+		panic("invalid nil handler")
+	}
+	g.onWriteBufferHigh = h
+}
+
+// OnWriteBufferLow registers callback for the pending write bytes of a conn falling to WriteBufferLowWatermark
+// after OnWriteBufferHigh was called.
+func (g *Gopher) OnWriteBufferLow(h func(c *Conn)) {
+	if h == nil {
+		panic("invalid nil handler")
+	}
+	g.onWriteBufferLow = h
+}
+
 // BeforeRead registers callback before syscall.Read
 // the handler would be called only on windows.
 func (g *Gopher) BeforeRead(h func(c *Conn)) {
@@ -257,6 +464,15 @@ func (g *Gopher) BeforeWrite(h func(c *Conn)) {
 	g.beforeWrite = h
 }
 
+// OnShutdown registers callback called by Shutdown after accepting is stopped,
+// it's a chance to notify the clients before the conns are closed.
+func (g *Gopher) OnShutdown(h func()) {
+	if h == nil {
+		panic("invalid nil handler")
+	}
+	g.onShutdown = h
+}
+
 // OnStop registers callback before Gopher is stopped.
 func (g *Gopher) OnStop(h func()) {
 	if h == nil {
@@ -274,7 +490,8 @@ func (g *Gopher) After(timeout time.Duration) <-chan time.Time {
 	return c
 }
 
-// AfterFunc used as time.AfterFunc.
+// AfterFunc used as time.AfterFunc, f is called by the Gopher timer goroutine.
+// conn deadlines and OnClose are run by the poller that owns the conn instead.
 func (g *Gopher) AfterFunc(timeout time.Duration, f func()) *Timer {
 	ht := g.afterFunc(timeout, f)
 	return &Timer{htimer: ht}
@@ -298,14 +515,13 @@ func (g *Gopher) afterFunc(timeout time.Duration, f func()) *htimer {
 
 	now := time.Now()
 	it := &htimer{
-		index:  len(g.timers),
+		index:  -1,
 		expire: now.Add(timeout),
 		f:      f,
 		parent: g,
 	}
-	heap.Push(&g.timers, it)
-	if g.timers[0] == it {
-		g.trigger.Reset(timeout)
+	if g.timers.push(it) {
+		g.resetTrigger(false)
 	}
 
 	return it
@@ -315,39 +531,39 @@ func (g *Gopher) removeTimer(it *htimer) {
 	g.tmux.Lock()
 	defer g.tmux.Unlock()
 
-	index := it.index
-	if index < 0 || index >= len(g.timers) {
-		return
+	if g.timers.remove(it) {
+		g.resetTrigger(false)
 	}
+}
 
-	if g.timers[index] == it {
-		heap.Remove(&g.timers, index)
-		if len(g.timers) > 0 {
-			if index == 0 {
-				g.trigger.Reset(time.Until(g.timers[0].expire))
+// ResetTimer removes a timer.
+func (g *Gopher) resetTimer(it *htimer) {
+	g.tmux.Lock()
+	defer g.tmux.Unlock()
 
-			}
-		} else {
-			g.trigger.Reset(timeForever)
-		}
+	if g.timers.fix(it) {
+		g.resetTrigger(false)
 	}
 }
 
-// ResetTimer removes a timer.
-func (g *Gopher) resetTimer(it *htimer) {
+func (g *Gopher) pendingTimers() int {
 	g.tmux.Lock()
 	defer g.tmux.Unlock()
+	return g.timers.Len()
+}
 
-	index := it.index
-	if index < 0 || index >= len(g.timers) {
+// resetTrigger resets the trigger to the next time to check timers, it should be called with tmux held.
+// the trigger is not reset if the time is not changed, unless force is set after the trigger fired.
+func (g *Gopher) resetTrigger(force bool) {
+	next, ok := g.timers.next()
+	if !force && next.Equal(g.triggerAt) {
 		return
 	}
-
-	if g.timers[index] == it {
-		heap.Fix(&g.timers, index)
-		if index == 0 || it.index == 0 {
-			g.trigger.Reset(time.Until(g.timers[0].expire))
-		}
+	g.triggerAt = next
+	if ok {
+		g.trigger.Reset(time.Until(next))
+	} else {
+		g.trigger.Reset(timeForever)
 	}
 }
 
@@ -384,33 +600,25 @@ func (g *Gopher) timerLoop() {
 		case <-g.trigger.C:
 			for {
 				g.tmux.Lock()
-				if g.timers.Len() == 0 {
-					g.trigger.Reset(timeForever)
+				it := g.timers.popExpired(time.Now())
+				if it == nil {
+					g.resetTrigger(true)
 					g.tmux.Unlock()
 					break
 				}
-				now := time.Now()
-				it := g.timers[0]
-				if now.After(it.expire) {
-					heap.Remove(&g.timers, it.index)
-					g.tmux.Unlock()
-					func() {
-						defer func() {
-							err := recover()
-							if err != nil {
-								const size = 64 << 10
-								buf := make([]byte, size)
-								buf = buf[:runtime.Stack(buf, false)]
-								logging.Error("Gopher[%v] exec timer failed: %v\n%v\n", g.Name, err, *(*string)(unsafe.Pointer(&buf)))
-							}
-						}()
-						it.f()
+				g.tmux.Unlock()
+				func() {
+					defer func() {
+						err := recover()
+						if err != nil {
+							const size = 64 << 10
+							buf := make([]byte, size)
+							buf = buf[:runtime.Stack(buf, false)]
+							logging.Error("Gopher[%v] exec timer failed: %v\n%v\n", g.Name, err, *(*string)(unsafe.Pointer(&buf)))
+						}
 					}()
-				} else {
-					g.trigger.Reset(it.expire.Sub(now))
-					g.tmux.Unlock()
-					break
-				}
+					it.f()
+				}()
 			}
 		case <-g.chTimer:
 			return
@@ -418,9 +626,65 @@ func (g *Gopher) timerLoop() {
 	}
 }
 
+// ConnCount returns the number of conns.
+func (g *Gopher) ConnCount() int {
+	g.mux.Lock()
+	n := len(g.connsStd)
+	g.mux.Unlock()
+	return n + g.connsUnix.len()
+}
+
+// Range calls f for every conn until f returns false.
+func (g *Gopher) Range(f func(c *Conn) bool) {
+	g.mux.Lock()
+	conns := make([]*Conn, 0, len(g.connsStd))
+	for c := range g.connsStd {
+		conns = append(conns, c)
+	}
+	g.mux.Unlock()
+	for _, c := range conns {
+		if !f(c) {
+			return
+		}
+	}
+	g.connsUnix.rangeConns(f)
+}
+
+// ConnByID returns the conn of id, it returns nil if the conn is closed.
+func (g *Gopher) ConnByID(id uint64) *Conn {
+	return g.connsByID.get(id)
+}
+
+// addConnID assigns an id to c and makes it found by ConnByID.
+func (g *Gopher) addConnID(c *Conn) {
+	c.id = atomic.AddUint64(&g.connID, 1)
+	g.connsByID.set(c.id, c)
+}
+
+func (g *Gopher) deleteConnID(c *Conn) {
+	g.connsByID.delete(c.id, c)
+}
+
+// WorkerStats returns the state of the built-in worker pool, it's empty if Config.NWorker is 0.
+func (g *Gopher) WorkerStats() WorkerStats {
+	if g.workers == nil {
+		return WorkerStats{}
+	}
+	return g.workers.stats()
+}
+
+// execute runs a job of c by the worker pool or Gopher.Execute, it returns false if the job is dropped.
+func (g *Gopher) execute(c *Conn, f func()) bool {
+	if g.workers != nil {
+		return g.workers.execute(c, f)
+	}
+	g.Execute(f)
+	return true
+}
+
 // PollerBuffer returns Poller's buffer by Conn, can be used on linux/bsd.
 func (g *Gopher) PollerBuffer(c *Conn) []byte {
-	return g.pollers[uint32(c.Hash())%uint32(g.pollerNum)].ReadBuffer
+	return c.p.ReadBuffer
 }
 
 func (g *Gopher) initHandlers() {
@@ -434,17 +698,27 @@ func (g *Gopher) initHandlers() {
 	// 	return nil, err
 	// })
 	g.OnData(func(c *Conn, data []byte) {})
+	g.OnPacket(func(c *PacketConn, from net.Addr, data []byte) {})
 	g.OnReadBufferAlloc(g.PollerBuffer)
 	g.OnReadBufferFree(func(c *Conn, buffer []byte) {})
 	g.OnWriteBufferRelease(func(c *Conn, buffer []byte) {})
+	g.OnWriteBufferHigh(func(c *Conn) {})
+	g.OnWriteBufferLow(func(c *Conn) {})
 	g.BeforeRead(func(c *Conn) {})
 	g.AfterRead(func(c *Conn) {})
 	g.BeforeWrite(func(c *Conn) {})
 	g.OnStop(func() {})
+	g.OnShutdown(func() {})
 
 	if g.Execute == nil {
-		g.Execute = func(f func()) {
-			f()
+		if g.workers != nil {
+			g.Execute = func(f func()) {
+				g.workers.execute(nil, f)
+			}
+		} else {
+			g.Execute = func(f func()) {
+				f()
+			}
 		}
 	}
 }
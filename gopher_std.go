@@ -58,6 +58,10 @@ func (g *Gopher) Start() error {
 	g.Add(1)
 	go g.timerLoop()
 
+	if g.workers != nil {
+		g.workers.start()
+	}
+
 	if len(g.addrs) == 0 {
 		logging.Info("Gopher[%v] start", g.Name)
 	} else {
@@ -66,6 +70,12 @@ func (g *Gopher) Start() error {
 	return nil
 }
 
+// closePollerListenFds is a no-op, listeners are not accepted by pollers with std net.
+func (g *Gopher) closePollerListenFds() {}
+
+// stopIdleSweep is a no-op, idle conns are not swept with std net.
+func (g *Gopher) stopIdleSweep() {}
+
 // NewGopher is a factory impl
 func NewGopher(conf Config) *Gopher {
 	cpuNum := runtime.NumCPU()
@@ -81,6 +91,18 @@ func NewGopher(conf Config) *Gopher {
 	if conf.MinConnCacheSize == 0 {
 		conf.MinConnCacheSize = DefaultMinConnCacheSize
 	}
+	if conf.MaxWriteBufferSize > 0 && conf.WriteBufferHighWatermark >= conf.MaxWriteBufferSize {
+		// the conn would be closed before the high watermark is reached.
+		logging.Warn("Gopher[%v] WriteBufferHighWatermark %v is not less than MaxWriteBufferSize %v, set to %v",
+			conf.Name, conf.WriteBufferHighWatermark, conf.MaxWriteBufferSize, conf.MaxWriteBufferSize/2)
+		conf.WriteBufferHighWatermark = conf.MaxWriteBufferSize / 2
+	}
+	if conf.WriteBufferHighWatermark > 0 && (conf.WriteBufferLowWatermark <= 0 || conf.WriteBufferLowWatermark >= conf.WriteBufferHighWatermark) {
+		conf.WriteBufferLowWatermark = conf.WriteBufferHighWatermark / 2
+	}
+	if conf.NWorker > 0 && conf.WorkerQueueSize <= 0 {
+		conf.WorkerQueueSize = DefaultWorkerQueueSize
+	}
 
 	g := &Gopher{
 		Name:               conf.Name,
@@ -89,6 +111,8 @@ func NewGopher(conf Config) *Gopher {
 		pollerNum:          conf.NPoller,
 		readBufferSize:     conf.ReadBufferSize,
 		maxWriteBufferSize: conf.MaxWriteBufferSize,
+		writeHighWatermark: conf.WriteBufferHighWatermark,
+		writeLowWatermark:  conf.WriteBufferLowWatermark,
 		minConnCacheSize:   conf.MinConnCacheSize,
 		lockListener:       conf.LockListener,
 		lockPoller:         conf.LockPoller,
@@ -96,11 +120,19 @@ func NewGopher(conf Config) *Gopher {
 		pollers:            make([]*poller, conf.NPoller),
 		connsStd:           map[*Conn]struct{}{},
 		callings:           []func(){},
+		connsByID:          newConnIDMap(),
 		chCalling:          make(chan struct{}, 1),
+		timers:             newTimerQueue(conf.TimerEngine, conf.TimerTick),
+		timerEngine:        conf.TimerEngine,
+		timerTick:          conf.TimerTick,
 		trigger:            time.NewTimer(timeForever),
 		chTimer:            make(chan struct{}),
 	}
 
+	if conf.NWorker > 0 {
+		g.workers = newWorkerPool(g, conf.NWorker, conf.WorkerQueueSize, conf.WorkerOverflow)
+	}
+
 	g.initHandlers()
 
 	g.OnReadBufferAlloc(func(c *Conn) []byte {
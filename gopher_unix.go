@@ -10,6 +10,7 @@ package easyNet
 import (
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,47 +21,91 @@ import (
 func (g *Gopher) Start() error {
 	var err error
 
-	for i := 0; i < len(g.addrs); i++ {
+	if g.network == "unixgram" && len(g.addrs) > 0 {
+		return errUnixgramUnsupported
+	}
+
+	for i := 0; i < len(g.listeners); i++ {
 		g.listeners[i], err = newPoller(g, true, i)
 		if err != nil {
-			for j := 0; j < i; j++ {
-				g.listeners[j].stop()
-			}
+			g.closeListenFds()
 			return err
 		}
 	}
 
+	if isPacketNetwork(g.network) {
+		for i := 0; i < len(g.addrs)*g.listenerNum; i++ {
+			var c *PacketConn
+			c, err = newPacketConn(g, g.addrs[i/g.listenerNum])
+			if err != nil {
+				g.closePacketConns()
+				return err
+			}
+			g.packetConns = append(g.packetConns, c)
+		}
+	}
+
 	for i := 0; i < g.pollerNum; i++ {
 		g.pollers[i], err = newPoller(g, false, i)
 		if err != nil {
-			for j := 0; j < len(g.lfds); j++ {
-				syscall.Close(g.lfds[j])
-			}
-
-			for j := 0; j < len(g.listeners); j++ {
-				g.listeners[j].stop()
+			g.closeListenFds()
+			g.closePacketConns()
+			for j := 0; j < i; j++ {
+				g.pollers[j].stop()
 			}
+			return err
+		}
+	}
 
-			for j := 0; j < i; j++ {
+	for i, c := range g.packetConns {
+		err = g.pollers[i%g.pollerNum].addPacketConn(c)
+		if err != nil {
+			g.closePacketConns()
+			for j := 0; j < g.pollerNum; j++ {
 				g.pollers[j].stop()
 			}
 			return err
 		}
 	}
 
+	if g.acceptInPoller {
+		for i := 0; i < g.pollerNum; i++ {
+			for _, l := range g.listeners {
+				err = g.pollers[i].addListener(l.lfd)
+				if err != nil {
+					g.closeListenFds()
+					for j := 0; j < g.pollerNum; j++ {
+						g.pollers[j].stop()
+					}
+					return err
+				}
+			}
+		}
+	}
+
 	for i := 0; i < g.pollerNum; i++ {
 		g.pollers[i].ReadBuffer = make([]byte, g.readBufferSize)
 		g.Add(1)
 		go g.pollers[i].start()
 	}
-	for _, l := range g.listeners {
-		g.Add(1)
-		go l.start()
+	if !g.acceptInPoller {
+		for _, l := range g.listeners {
+			g.Add(1)
+			go l.start()
+		}
 	}
 
 	g.Add(1)
 	go g.timerLoop()
 
+	if g.idleTimeout > 0 {
+		g.startIdleSweep()
+	}
+
+	if g.workers != nil {
+		g.workers.start()
+	}
+
 	if len(g.addrs) == 0 {
 		logging.Info("Gopher[%v] start", g.Name)
 	} else {
@@ -69,6 +114,88 @@ func (g *Gopher) Start() error {
 	return nil
 }
 
+// PollerNum returns the number of pollers.
+func (g *Gopher) PollerNum() int {
+	return g.pollerNum
+}
+
+// PollerConns returns the number of conns served by each poller.
+func (g *Gopher) PollerConns() []int {
+	conns := make([]int, g.pollerNum)
+	for i := range conns {
+		conns[i] = g.pollerConnNum(i)
+	}
+	return conns
+}
+
+func (g *Gopher) pollerConnNum(i int) int {
+	if p := g.pollers[i]; p != nil {
+		return int(atomic.LoadInt64(&p.connNum))
+	}
+	return 0
+}
+
+// pollerStats fills the per poller fields of st.
+func (g *Gopher) pollerStats(st *Stats) {
+	st.PollerConns = make([]int, g.pollerNum)
+	st.PollerWakeups = make([]uint64, g.pollerNum)
+	for i, p := range g.pollers {
+		if p == nil {
+			continue
+		}
+		st.PollerConns[i] = int(atomic.LoadInt64(&p.connNum))
+		st.PollerWakeups[i] = atomic.LoadUint64(&p.wakeups)
+		st.BytesRead += atomic.LoadUint64(&p.bytesRead)
+		st.BytesWritten += atomic.LoadUint64(&p.bytesWritten)
+		st.PendingTimers += p.pendingTimers()
+	}
+}
+
+func (g *Gopher) pickPoller(c *Conn) *poller {
+	if g.balancer == nil {
+		return g.pollers[uint32(c.Hash())%uint32(g.pollerNum)]
+	}
+	i := g.balancer.Pick(g, c)
+	if i < 0 || i >= g.pollerNum {
+		i = int(uint32(i) % uint32(g.pollerNum))
+	}
+	return g.pollers[i]
+}
+
+// closeListenFds closes listening fds before listener goroutines started.
+func (g *Gopher) closeListenFds() {
+	for _, fd := range g.lfds {
+		syscall.Close(fd)
+	}
+	g.lfds = nil
+	for _, l := range g.listeners {
+		if l != nil && l.epfd >= 0 {
+			syscall.Close(l.epfd)
+			syscall.Close(l.evtfd)
+		}
+	}
+}
+
+// closePollerListenFds closes the listening fds accepted by pollers in AcceptInPoller mod after the pollers exit.
+func (g *Gopher) closePollerListenFds() {
+	if !g.acceptInPoller {
+		return
+	}
+	for _, l := range g.listeners {
+		if l != nil {
+			syscall.Close(l.lfd)
+		}
+	}
+}
+
+// closePacketConns closes udp sockets before pollers started.
+func (g *Gopher) closePacketConns() {
+	for _, c := range g.packetConns {
+		syscall.Close(c.fd)
+	}
+	g.packetConns = nil
+}
+
 // NewGopher is a factory impl.
 func NewGopher(conf Config) *Gopher {
 	cpuNum := runtime.NumCPU()
@@ -94,28 +221,67 @@ func NewGopher(conf Config) *Gopher {
 		conf.MaxReadTimesPerEventLoop = DefaultMaxReadTimesPerEventLoop
 	}
 
+	if conf.MaxWriteBufferSize > 0 && conf.WriteBufferHighWatermark >= conf.MaxWriteBufferSize {
+		// the conn would be closed before the high watermark is reached.
+		logging.Warn("Gopher[%v] WriteBufferHighWatermark %v is not less than MaxWriteBufferSize %v, set to %v",
+			conf.Name, conf.WriteBufferHighWatermark, conf.MaxWriteBufferSize, conf.MaxWriteBufferSize/2)
+		conf.WriteBufferHighWatermark = conf.MaxWriteBufferSize / 2
+	}
+	if conf.WriteBufferHighWatermark > 0 && (conf.WriteBufferLowWatermark <= 0 || conf.WriteBufferLowWatermark >= conf.WriteBufferHighWatermark) {
+		conf.WriteBufferLowWatermark = conf.WriteBufferHighWatermark / 2
+	}
+	if conf.NWorker > 0 && conf.WorkerQueueSize <= 0 {
+		conf.WorkerQueueSize = DefaultWorkerQueueSize
+	}
+	if isUnixNetwork(conf.Network) {
+		// a unix socket path can not be shared by SO_REUSEPORT.
+		conf.NListener = 1
+	}
+
+	nListener := len(conf.Addrs) * conf.NListener
+	if isPacketNetwork(conf.Network) {
+		// udp sockets are served by pollers directly.
+		nListener = 0
+	}
+
 	g := &Gopher{
 		Name:                     conf.Name,
 		network:                  conf.Network,
 		addrs:                    conf.Addrs,
 		pollerNum:                conf.NPoller,
+		listenerNum:              conf.NListener,
 		backlogSize:              conf.Backlog,
 		readBufferSize:           conf.ReadBufferSize,
 		maxWriteBufferSize:       conf.MaxWriteBufferSize,
+		writeHighWatermark:       conf.WriteBufferHighWatermark,
+		writeLowWatermark:        conf.WriteBufferLowWatermark,
 		maxReadTimesPerEventLoop: conf.MaxReadTimesPerEventLoop,
 		minConnCacheSize:         conf.MinConnCacheSize,
 		epollMod:                 conf.EpollMod,
+		ioMod:                    conf.IOMod,
+		acceptInPoller:           conf.AcceptInPoller,
+		listenerNames:            conf.ListenerNames,
+		idleTimeout:              conf.IdleTimeout,
+		balancer:                 conf.Balancer,
 		lockListener:             conf.LockListener,
 		lockPoller:               conf.LockPoller,
-		listeners:                make([]*poller, len(conf.Addrs)),
+		listeners:                make([]*poller, nListener),
 		pollers:                  make([]*poller, conf.NPoller),
-		connsUnix:                make([]*Conn, MaxOpenFiles),
+		connsUnix:                newConnTable(),
 		callings:                 []func(){},
+		connsByID:                newConnIDMap(),
 		chCalling:                make(chan struct{}, 1),
+		timers:                   newTimerQueue(conf.TimerEngine, conf.TimerTick),
+		timerEngine:              conf.TimerEngine,
+		timerTick:                conf.TimerTick,
 		trigger:                  time.NewTimer(timeForever),
 		chTimer:                  make(chan struct{}),
 	}
 
+	if conf.NWorker > 0 {
+		g.workers = newWorkerPool(g, conf.NWorker, conf.WorkerQueueSize, conf.WorkerOverflow)
+	}
+
 	g.initHandlers()
 
 	return g
@@ -0,0 +1,215 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+const (
+	// SlowConsumerSkip skips the members whose write buffer exceeds the threshold.
+	SlowConsumerSkip = 0
+
+	// SlowConsumerClose closes the members whose write buffer exceeds the threshold.
+	SlowConsumerClose = 1
+)
+
+// Group is a named set of conns for broadcasting, a conn leaves all its groups when it's closed.
+type Group struct {
+	mux sync.RWMutex
+
+	g    *Gopher
+	name string
+
+	members map[*Conn]struct{}
+
+	slowPolicy    int
+	slowThreshold int
+}
+
+// Group returns the group of name, it's created if not exists.
+func (g *Gopher) Group(name string) *Group {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.groups == nil {
+		g.groups = map[string]*Group{}
+	}
+	gr, ok := g.groups[name]
+	if !ok {
+		gr = &Group{
+			g:       g,
+			name:    name,
+			members: map[*Conn]struct{}{},
+		}
+		g.groups[name] = gr
+	}
+	return gr
+}
+
+// DeleteGroup removes all members from the group of name and deletes it.
+func (g *Gopher) DeleteGroup(name string) {
+	g.mux.Lock()
+	gr, ok := g.groups[name]
+	delete(g.groups, name)
+	g.mux.Unlock()
+	if !ok {
+		return
+	}
+
+	gr.mux.Lock()
+	members := gr.members
+	gr.members = map[*Conn]struct{}{}
+	gr.mux.Unlock()
+	for c := range members {
+		c.removeGroup(gr)
+	}
+}
+
+// Name returns the group name.
+func (gr *Group) Name() string {
+	return gr.name
+}
+
+// SetSlowConsumerPolicy sets how Broadcast handles the members whose pending write buffer is
+// larger than threshold, threshold 0 means no limit.
+func (gr *Group) SetSlowConsumerPolicy(policy int, threshold int) {
+	gr.mux.Lock()
+	gr.slowPolicy = policy
+	gr.slowThreshold = threshold
+	gr.mux.Unlock()
+}
+
+// Join adds c to the group, it returns an error if c is closed.
+func (gr *Group) Join(c *Conn) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		return errClosed
+	}
+
+	gr.mux.Lock()
+	_, ok := gr.members[c]
+	gr.members[c] = struct{}{}
+	gr.mux.Unlock()
+	if !ok {
+		c.groups = append(c.groups, gr)
+	}
+	return nil
+}
+
+// Leave removes c from the group.
+func (gr *Group) Leave(c *Conn) {
+	gr.mux.Lock()
+	delete(gr.members, c)
+	gr.mux.Unlock()
+	c.removeGroup(gr)
+}
+
+// Len returns the number of members.
+func (gr *Group) Len() int {
+	gr.mux.RLock()
+	defer gr.mux.RUnlock()
+	return len(gr.members)
+}
+
+// Range calls f for every member until f returns false.
+func (gr *Group) Range(f func(c *Conn) bool) {
+	for _, c := range gr.snapshot() {
+		if !f(c) {
+			return
+		}
+	}
+}
+
+// Broadcast writes data to all members, data is encoded once for the members sharing a codec,
+// the conn passed to ICodec.Encode is the first of them, so such a codec should not depend on the conn.
+// a codec of a type that is not comparable, such as a struct value holding a slice, is called for every
+// member with its own conn. it returns the number of members written.
+func (gr *Group) Broadcast(data []byte) int {
+	gr.mux.RLock()
+	policy, threshold := gr.slowPolicy, gr.slowThreshold
+	gr.mux.RUnlock()
+
+	var encoded map[ICodec][]byte
+	n := 0
+	for _, c := range gr.snapshot() {
+		b := data
+		if codec := c.getCodec(); codec != nil {
+			// a codec of a non-comparable type would panic as a map key.
+			cached, ok := false, reflect.TypeOf(codec).Comparable()
+			if ok {
+				if encoded == nil {
+					encoded = map[ICodec][]byte{}
+				}
+				b, cached = encoded[codec]
+			}
+			if !cached {
+				var err error
+				if b, err = codec.Encode(c, data); err != nil {
+					logging.Error("Group[%v] encode failed: %v", gr.name, err)
+					b = nil
+				}
+				if ok {
+					encoded[codec] = b
+				}
+			}
+			if b == nil {
+				continue
+			}
+		}
+
+		_, slow, err := c.writeUnlessSlow(b, threshold, nil)
+		if slow {
+			if policy == SlowConsumerClose {
+				c.CloseWithError(errSlowConsumer)
+			}
+			continue
+		}
+		if err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (gr *Group) snapshot() []*Conn {
+	gr.mux.RLock()
+	defer gr.mux.RUnlock()
+	conns := make([]*Conn, 0, len(gr.members))
+	for c := range gr.members {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (c *Conn) removeGroup(gr *Group) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, v := range c.groups {
+		if v == gr {
+			last := len(c.groups) - 1
+			c.groups[i] = c.groups[last]
+			c.groups[last] = nil
+			c.groups = c.groups[:last]
+			return
+		}
+	}
+}
+
+// leaveGroups removes c from all its groups, it's called after c is closed.
+func (c *Conn) leaveGroups() {
+	c.mux.Lock()
+	groups := c.groups
+	c.groups = nil
+	c.mux.Unlock()
+	for _, gr := range groups {
+		gr.mux.Lock()
+		delete(gr.members, c)
+		gr.mux.Unlock()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package easyNet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the idle sweeper checks idleSweepPages pages of the conn table every idleSweepInterval,
+	// a full round of 1m fds takes about 1.6 seconds.
+	idleSweepInterval = time.Second / 10
+	idleSweepPages    = 16
+)
+
+// the states of Gopher.idleSweeping.
+const (
+	idleSweepIdle int32 = iota
+	idleSweepRunning
+	idleSweepStopped
+)
+
+// SetIdleTimeout overrides Config.IdleTimeout for the conn, 0 means using Config.IdleTimeout,
+// and a negative timeout disables it.
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&c.idleTimeout, int64(timeout))
+	if timeout > 0 && c.g != nil {
+		c.g.startIdleSweep()
+	}
+}
+
+func (c *Conn) getIdleTimeout() time.Duration {
+	if timeout := time.Duration(atomic.LoadInt64(&c.idleTimeout)); timeout != 0 {
+		return timeout
+	}
+	return c.g.idleTimeout
+}
+
+// lastActive returns the last time the conn read or wrote, or the open time if it never did.
+func (c *Conn) lastActive() int64 {
+	last := atomic.LoadInt64(&c.openTime)
+	if t := atomic.LoadInt64(&c.lastRead); t > last {
+		last = t
+	}
+	if t := atomic.LoadInt64(&c.lastWrite); t > last {
+		last = t
+	}
+	return last
+}
+
+// startIdleSweep starts the idle sweeper on the timer goroutine once, it's not started after Stop.
+func (g *Gopher) startIdleSweep() {
+	if atomic.CompareAndSwapInt32(&g.idleSweeping, idleSweepIdle, idleSweepRunning) {
+		g.afterFunc(idleSweepInterval, g.sweepIdleConns)
+	}
+}
+
+// stopIdleSweep keeps the idle sweeper from re-arming itself.
+func (g *Gopher) stopIdleSweep() {
+	atomic.StoreInt32(&g.idleSweeping, idleSweepStopped)
+}
+
+// sweepIdleConns checks a part of the conn table each time, so that it's cheap with lots of conns.
+func (g *Gopher) sweepIdleConns() {
+	if atomic.LoadInt32(&g.idleSweeping) == idleSweepStopped {
+		return
+	}
+	now := time.Now().UnixNano()
+	g.idleCursor = g.connsUnix.rangePages(g.idleCursor, idleSweepPages, func(c *Conn) {
+		timeout := c.getIdleTimeout()
+		if timeout > 0 && now-c.lastActive() > int64(timeout) {
+			c.CloseWithError(ErrIdleTimeout)
+		}
+	})
+	g.afterFunc(idleSweepInterval, g.sweepIdleConns)
+}
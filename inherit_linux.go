@@ -0,0 +1,317 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package easyNet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+// ListenerFdsEnv is the environment variable used to pass listener fds to a child process,
+// its value is in the format of "addr=fd;addr=fd", addr is the same as in Config.Addrs.
+var ListenerFdsEnv = "EASYNET_LISTENER_FDS"
+
+// PassListeners dups the listener fds to cmd.ExtraFiles and describes them by ListenerFdsEnv in cmd.Env,
+// a Gopher started by the child process adopts the fds of the same addrs instead of binding again.
+// it can be called by several Gophers with the same cmd, the fds of each are added to ListenerFdsEnv.
+// the files appended to cmd.ExtraFiles should be closed by the caller after cmd.Start.
+// once passed, the unix socket files are not removed when the listeners are stopped.
+func (g *Gopher) PassListeners(cmd *exec.Cmd) error {
+	if len(g.listeners) == 0 {
+		return errNoListener
+	}
+
+	var items []string
+	var files []*os.File
+	for i, l := range g.listeners {
+		if l == nil {
+			return errNoListener
+		}
+		fd, err := syscall.Dup(l.lfd)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return err
+		}
+		addr := g.addrs[i/g.listenerNum]
+		files = append(files, os.NewFile(uintptr(fd), addr))
+		items = append(items, fmt.Sprintf("%v=%v", addr, 3+len(cmd.ExtraFiles)+len(files)-1))
+	}
+
+	// the variables passed to this process must not be taken as the ones passed to the child.
+	inheritedListeners.mux.Lock()
+	loadInheritedListeners()
+	inheritedListeners.mux.Unlock()
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	prefix := ListenerFdsEnv + "="
+	cmd.Env = make([]string, 0, len(env)+1)
+	for _, v := range env {
+		if !strings.HasPrefix(v, prefix) {
+			cmd.Env = append(cmd.Env, v)
+			continue
+		}
+		// the fds passed to the same cmd by another Gopher.
+		if v = v[len(prefix):]; v != "" {
+			items = append(strings.Split(v, ";"), items...)
+		}
+	}
+	cmd.Env = append(cmd.Env, prefix+strings.Join(items, ";"))
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+
+	g.mux.Lock()
+	g.passed = true
+	g.mux.Unlock()
+
+	return nil
+}
+
+func (g *Gopher) listenersPassed() bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.passed
+}
+
+// listenFdsStart is the first fd passed by systemd socket activation.
+var listenFdsStart = 3
+
+// inheritedListeners are the listener fds passed by the parent process or systemd, they're shared by
+// all the Gophers of the process, and each fd is adopted by the first Gopher that claims it.
+var inheritedListeners struct {
+	mux sync.Mutex
+
+	// byAddr are the fds passed by ListenerFdsEnv.
+	byAddr map[string][]int
+
+	// systemd are the fds passed by systemd socket activation, in the order of LISTEN_FDS.
+	systemd []systemdListener
+}
+
+type systemdListener struct {
+	fd   int
+	name string
+}
+
+// loadInheritedListeners moves the fds described by the environment variables to inheritedListeners,
+// the variables are unset so that they're parsed once and not passed to grandchildren by accident.
+// it must be called with inheritedListeners.mux held.
+func loadInheritedListeners() {
+	t := &inheritedListeners
+	if t.byAddr == nil {
+		t.byAddr = map[string][]int{}
+	}
+	loadSystemdListeners()
+
+	v, ok := os.LookupEnv(ListenerFdsEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(ListenerFdsEnv)
+
+	for _, item := range strings.Split(v, ";") {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(item[i+1:])
+		if err != nil || fd < 0 {
+			logging.Error("invalid inherited listener: %v", item)
+			continue
+		}
+		addr := item[:i]
+		t.byAddr[addr] = append(t.byAddr[addr], fd)
+	}
+}
+
+// loadSystemdListeners collects the fds passed by systemd socket activation, they're matched to addrs
+// by the Gophers claiming them.
+func loadSystemdListeners() {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || n <= 0 {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		l := systemdListener{fd: listenFdsStart + i}
+		if i < len(names) {
+			l.name = names[i]
+		}
+		inheritedListeners.systemd = append(inheritedListeners.systemd, l)
+	}
+}
+
+// claimInheritedFd takes an inherited fd of addr out of inheritedListeners, it returns -1 if there's none.
+// a systemd fd is matched to addr by its name in LISTEN_FDNAMES and Config.ListenerNames, or by its local addr
+// if its name is not in Config.ListenerNames. fds of another socket type are left to other Gophers.
+func (g *Gopher) claimInheritedFd(addr string) int {
+	t := &inheritedListeners
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	loadInheritedListeners()
+
+	fds := t.byAddr[addr]
+	for i, fd := range fds {
+		if g.matchSocketType(fd) {
+			t.byAddr[addr] = append(fds[:i:i], fds[i+1:]...)
+			if len(t.byAddr[addr]) == 0 {
+				delete(t.byAddr, addr)
+			}
+			return fd
+		}
+	}
+
+	for i, l := range t.systemd {
+		named := g.listenerNames[l.name]
+		if !g.matchSocketType(l.fd) || named != addr && (named != "" || !g.matchListenerAddr(l.fd, addr)) {
+			continue
+		}
+		t.systemd = append(t.systemd[:i:i], t.systemd[i+1:]...)
+		return l.fd
+	}
+
+	return -1
+}
+
+// matchSocketType returns false if fd is a socket of another type than the network of g,
+// an invalid fd is reported by the adopting.
+func (g *Gopher) matchSocketType(fd int) bool {
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return true
+	}
+	switch {
+	case isPacketNetwork(g.network):
+		return typ == syscall.SOCK_DGRAM
+	case g.network == "unixpacket":
+		return typ == syscall.SOCK_SEQPACKET
+	}
+	return typ == syscall.SOCK_STREAM
+}
+
+// matchListenerAddr returns true if fd is bound to addr.
+func (g *Gopher) matchListenerAddr(fd int, addr string) bool {
+	switch a := localAddr(fd, g.network).(type) {
+	case *net.TCPAddr:
+		ta, err := net.ResolveTCPAddr(g.network, addr)
+		return err == nil && ta.Port == a.Port && matchIP(ta.IP, a.IP)
+	case *net.UDPAddr:
+		ua, err := net.ResolveUDPAddr(g.network, addr)
+		return err == nil && ua.Port == a.Port && matchIP(ua.IP, a.IP)
+	case *net.UnixAddr:
+		return addr == a.Name
+	}
+	return false
+}
+
+// matchIP returns true if the bound ip is the configured one, or both of them are wildcard.
+func matchIP(configured, bound net.IP) bool {
+	return configured.Equal(bound) || (configured == nil || configured.IsUnspecified()) && bound.IsUnspecified()
+}
+
+// adoptListener takes an inherited listener fd of addr, it returns -1 if there's none.
+// the unix socket file of an adopted listener is not removed when the listener is stopped,
+// since it's created by the parent process or systemd which may still be using it.
+func (g *Gopher) adoptListener(addr string) (int, net.Addr, error) {
+	fd := g.claimInheritedFd(addr)
+	if fd < 0 {
+		return -1, nil, nil
+	}
+
+	accepting, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if err != nil {
+		return -1, nil, fmt.Errorf("invalid inherited listener fd %v of %v: %v", fd, addr, err)
+	}
+	if accepting == 0 {
+		syscall.Close(fd)
+		return -1, nil, fmt.Errorf("invalid inherited listener fd %v of %v: not listening", fd, addr)
+	}
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return -1, nil, err
+	}
+
+	logging.Info("Gopher[%v] adopt inherited listener fd %v of %v", g.Name, fd, addr)
+
+	return fd, localAddr(fd, g.network), nil
+}
+
+// adoptPacketConn takes an inherited udp socket fd of addr, such as a systemd datagram socket,
+// it returns -1 if there's none.
+func (g *Gopher) adoptPacketConn(addr string) (int, int, net.Addr, error) {
+	fd := g.claimInheritedFd(addr)
+	if fd < 0 {
+		return -1, 0, nil, nil
+	}
+
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return -1, 0, nil, fmt.Errorf("invalid inherited udp fd %v of %v: %v", fd, addr, err)
+	}
+	family := syscall.AF_INET
+	switch sa.(type) {
+	case *syscall.SockaddrInet4:
+	case *syscall.SockaddrInet6:
+		family = syscall.AF_INET6
+	default:
+		syscall.Close(fd)
+		return -1, 0, nil, fmt.Errorf("invalid inherited udp fd %v of %v: not an inet socket", fd, addr)
+	}
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return -1, 0, nil, err
+	}
+
+	logging.Info("Gopher[%v] adopt inherited udp fd %v of %v", g.Name, fd, addr)
+
+	return fd, family, sockaddrToAddr(g.network, sa), nil
+}
+
+// CloseInheritedListeners closes the listener fds passed by the parent process or systemd that are not adopted
+// by any Gopher. the fds are kept open until it's called, so that Gophers started later can still adopt them,
+// it should be called once all the Gophers of the process are started.
+func CloseInheritedListeners() {
+	t := &inheritedListeners
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	loadInheritedListeners()
+
+	for addr, fds := range t.byAddr {
+		for _, fd := range fds {
+			logging.Error("inherited listener fd %v of %v is not adopted, closed", fd, addr)
+			syscall.Close(fd)
+		}
+	}
+	t.byAddr = map[string][]int{}
+	for _, l := range t.systemd {
+		logging.Error("systemd listener fd %v is not adopted, closed", l.fd)
+		syscall.Close(l.fd)
+	}
+	t.systemd = nil
+}
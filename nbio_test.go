@@ -1,13 +1,26 @@
 package easyNet
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -232,6 +245,76 @@ func TestHeapTimer(t *testing.T) {
 	testHeapTimerExecManyRandtime(g)
 }
 
+func TestWheelTimer(t *testing.T) {
+	g := NewGopher(Config{
+		TimerEngine: TimerEngineWheel,
+		TimerTick:   time.Millisecond * 5,
+	})
+	g.Start()
+	defer g.Stop()
+
+	timeout := time.Second / 10
+
+	testHeapTimerNormal(g, timeout)
+	testHeapTimerExecPanic(g, timeout)
+	testHeapTimerNormalExecMany(g, timeout)
+	testHeapTimerExecManyRandtime(g)
+}
+
+func TestTimerWheelCascade(t *testing.T) {
+	w := newTimerWheel(time.Millisecond)
+	ticks := []uint64{1, 2, 63, 64, 65, 4095, 4096, 4097, 100000, 1 << 24, 1<<30 + 12345}
+	for i := len(ticks) - 1; i >= 0; i-- {
+		w.push(&htimer{index: -1, expire: w.start.Add(time.Duration(ticks[i]) * time.Millisecond), f: func() {}})
+	}
+	// a removed timer never fires.
+	removed := &htimer{index: -1, expire: w.start.Add(time.Millisecond * 3000)}
+	w.push(removed)
+	w.remove(removed)
+
+	for _, tick := range ticks {
+		next, ok := w.next()
+		if !ok || next.After(w.timeOf(tick)) {
+			t.Fatalf("invalid next for tick %v: %v, %v", tick, next.Sub(w.start), ok)
+		}
+		if it := w.popExpired(w.timeOf(tick).Add(-1)); it != nil {
+			t.Fatalf("timer of tick %v fired before tick %v", w.expireTick(it.expire), tick)
+		}
+		it := w.popExpired(w.timeOf(tick).Add(1))
+		if it == nil || w.expireTick(it.expire) != tick {
+			t.Fatalf("timer of tick %v not fired", tick)
+		}
+	}
+	if w.Len() != 0 {
+		t.Fatalf("invalid timer num: %v", w.Len())
+	}
+}
+
+func benchmarkTimer(b *testing.B, engine int) {
+	g := NewGopher(Config{
+		TimerEngine: engine,
+	})
+	for i := 0; i < 100000; i++ {
+		g.afterFunc(time.Hour+time.Duration(i)*time.Millisecond, func() {})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			it := g.afterFunc(time.Second*time.Duration(1+i%10), func() {})
+			it.Reset(time.Second * time.Duration(1+i%20))
+			it.Stop()
+		}
+	})
+}
+
+func BenchmarkTimerHeap(b *testing.B) {
+	benchmarkTimer(b, TimerEngineHeap)
+}
+
+func BenchmarkTimerWheel(b *testing.B) {
+	benchmarkTimer(b, TimerEngineWheel)
+}
+
 func testHeapTimerNormal(g *Gopher, timeout time.Duration) {
 	t1 := time.Now()
 	ch1 := make(chan int)
@@ -308,8 +391,8 @@ func testHeapTimerExecManyRandtime(g *Gopher) {
 			ch5 <- n
 		}))
 	}
-	if len(its) != 100 || g.timers.Len() != 100 {
-		log.Panicf("invalid timers length: %v, %v", len(its), g.timers.Len())
+	if len(its) != 100 || g.pendingTimers() != 100 {
+		log.Panicf("invalid timers length: %v, %v", len(its), g.pendingTimers())
 	}
 	for i := 0; i < 50; i++ {
 		if its[0] == nil {
@@ -318,8 +401,8 @@ func testHeapTimerExecManyRandtime(g *Gopher) {
 		its[0].Stop()
 		its = its[1:]
 	}
-	if len(its) != 50 || g.timers.Len() != 50 {
-		log.Panicf("invalid timers length: %v, %v", len(its), g.timers.Len())
+	if len(its) != 50 || g.pendingTimers() != 50 {
+		log.Panicf("invalid timers length: %v, %v", len(its), g.pendingTimers())
 	}
 	recved := 0
 LOOP_RECV:
@@ -420,3 +503,2173 @@ func TestClose(t *testing.T) {
 		}
 	}
 }
+
+// testIOMods are the event engines the backend dependent tests run against.
+var testIOMods = []struct {
+	name string
+	mod  int
+}{
+	{"epoll", IOModEpoll},
+	{"uring", IOModUring},
+}
+
+// skipIfNoUring skips the test if the kernel does not support io_uring.
+func skipIfNoUring(t *testing.T) {
+	u, err := newIOUring(8)
+	if err != nil {
+		t.Skipf("io_uring unsupported: %v", err)
+	}
+	u.close()
+}
+
+// forEachIOMod runs f as a subtest for every IOMod with a Config listening on network addr.
+func forEachIOMod(t *testing.T, network, addr string, f func(t *testing.T, conf Config)) {
+	for _, m := range testIOMods {
+		m := m
+		t.Run(m.name, func(t *testing.T) {
+			if m.mod == IOModUring {
+				skipIfNoUring(t)
+			}
+			f(t, Config{IOMod: m.mod, Network: network, Addrs: []string{addr}})
+		})
+	}
+}
+
+// startTestServer starts an echo server on addr, it sends testfile on "sendfile".
+func startTestServer(addr string, ioMod int) *Gopher {
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{addr},
+		IOMod:   ioMod,
+	})
+
+	g.OnOpen(func(c *Conn) {
+		c.SetReadDeadline(time.Now().Add(time.Second * 10))
+	})
+	g.OnData(func(c *Conn, data []byte) {
+		if len(data) == 8 && string(data) == "sendfile" {
+			fd, err := os.Open(testfile)
+			if err != nil {
+				log.Panicf("open file failed: %v", err)
+			}
+
+			if _, err = c.SendFile(fd, 0, -1); err != nil {
+				panic(err)
+			}
+
+			if err := fd.Close(); err != nil {
+				log.Panicf("close file failed: %v", err)
+			}
+		} else {
+			c.Write(append([]byte{}, data...))
+		}
+	})
+	g.OnClose(func(c *Conn, err error) {})
+
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+
+	return g
+}
+
+// TestMain starts the server at addr used by TestEcho, TestTimeout and TestFuzz, TestStop stops it.
+func TestMain(m *testing.M) {
+	if err := os.WriteFile(testfile, make([]byte, 1024*100), 0600); err != nil {
+		log.Panicf("write file failed: %v", err)
+	}
+	gopher = startTestServer(addr, IOModEpoll)
+
+	code := m.Run()
+	os.Remove(testfile)
+	os.Exit(code)
+}
+
+var uringAddr = "127.0.0.1:8893"
+
+func TestUringEcho(t *testing.T) {
+	skipIfNoUring(t)
+	testEchoServer(t, Config{Network: "tcp", Addrs: []string{"127.0.0.1:8890"}, IOMod: IOModUring})
+}
+
+func TestUringTimeout(t *testing.T) {
+	skipIfNoUring(t)
+	svr := startTestServer(uringAddr, IOModUring)
+	defer svr.Stop()
+
+	g := NewGopher(Config{IOMod: IOModUring})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	var done = make(chan int)
+	var begin time.Time
+	var timeout = time.Second
+	g.OnOpen(func(c *Conn) {
+		begin = time.Now()
+		c.SetReadDeadline(begin.Add(timeout))
+	})
+	g.OnClose(func(c *Conn, err error) {
+		to := time.Since(begin)
+		if to > timeout*2 {
+			log.Panicf("timeout: %v, want: %v", to, timeout)
+		}
+		close(done)
+	})
+
+	c, err := DialTimeout("tcp", uringAddr, time.Second)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	g.AddConn(c)
+
+	<-done
+}
+
+func TestUringFuzz(t *testing.T) {
+	skipIfNoUring(t)
+	svr := startTestServer(uringAddr, IOModUring)
+	defer svr.Stop()
+
+	var readed int64
+	var done = make(chan int)
+	g := NewGopher(Config{NPoller: 1, IOMod: IOModUring})
+	g.OnData(func(c *Conn, data []byte) {
+		if atomic.AddInt64(&readed, int64(len(data))) == 4 {
+			close(done)
+		}
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v", err)
+	}
+	defer g.Stop()
+
+	c, err := Dial("tcp", uringAddr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	g.AddConn(c)
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	c.Write([]byte{1})
+	c.Writev([][]byte{{2}, {3}, {4}})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("echo timeout, recved: %v", atomic.LoadInt64(&readed))
+	}
+
+	c.Close()
+	if _, err = c.Write([]byte{1}); err == nil {
+		t.Fatalf("Write succeeded after Close")
+	}
+
+	gErr := NewGopher(Config{
+		Network: "tcp4",
+		Addrs:   []string{"127.0.0.1:8889", "127.0.0.1:8889"},
+		IOMod:   IOModUring,
+	})
+	if err = gErr.Start(); err == nil {
+		gErr.Stop()
+		t.Fatalf("Start succeeded with duplicated addrs")
+	}
+}
+
+func TestUringStop(t *testing.T) {
+	skipIfNoUring(t)
+	g := startTestServer(uringAddr, IOModUring)
+	g.Stop()
+
+	if _, err := net.DialTimeout("tcp", uringAddr, time.Second); err == nil {
+		t.Fatalf("server not stopped")
+	}
+}
+
+func TestUringCloseOnExec(t *testing.T) {
+	skipIfNoUring(t)
+	g := NewGopher(Config{IOMod: IOModUring})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	for _, p := range g.pollers {
+		for _, fd := range []int{p.ring.fd, p.evtfd} {
+			flags, _, e0 := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+			if e0 != 0 || flags&syscall.FD_CLOEXEC == 0 {
+				t.Fatalf("FD_CLOEXEC not set on fd %v: %v, %v", fd, flags, e0)
+			}
+		}
+	}
+}
+
+func testEchoServer(t *testing.T, conf Config) {
+	var clientNum = 4
+	var msgSize = 1024 * 256
+	var total int64 = 0
+	var done = make(chan int)
+
+	addr := conf.Addrs[0]
+	svr := NewGopher(conf)
+	svr.OnData(func(c *Conn, data []byte) {
+		c.Write(append([]byte{}, data...))
+	})
+	err := svr.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer svr.Stop()
+
+	conf.Addrs = nil
+	cli := NewGopher(conf)
+	cli.OnData(func(c *Conn, data []byte) {
+		recved := atomic.AddInt64(&total, int64(len(data)))
+		if recved == int64(clientNum*msgSize) {
+			close(done)
+		}
+	})
+	err = cli.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer cli.Stop()
+
+	for i := 0; i < clientNum; i++ {
+		c, err := Dial("tcp", addr)
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		cli.AddConn(c)
+		c.Write(make([]byte, msgSize))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatalf("echo timeout, recved: %v, want: %v", atomic.LoadInt64(&total), clientNum*msgSize)
+	}
+}
+
+func TestReusePortListeners(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8891", func(t *testing.T, conf Config) {
+		conf.NListener = 4
+		g := NewGopher(conf)
+		err := g.Start()
+		if err != nil {
+			log.Panicf("Start failed: %v\n", err)
+		}
+		if len(g.listeners) != 4 {
+			t.Fatalf("invalid listener num: %v", len(g.listeners))
+		}
+		for _, l := range g.listeners {
+			v, err := syscall.GetsockoptInt(l.lfd, syscall.SOL_SOCKET, soReusePort)
+			if err != nil || v != 1 {
+				t.Fatalf("SO_REUSEPORT not set on listener %v: %v, %v", l.lfd, v, err)
+			}
+		}
+		g.Stop()
+
+		testEchoServer(t, conf)
+	})
+}
+
+func TestLocalAddr(t *testing.T) {
+	var done = make(chan string, 8)
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"0.0.0.0:8920"},
+	})
+	g.OnOpen(func(c *Conn) {
+		// LocalAddr of a conn accepted by a wildcard listener is called by several goroutines at the same time.
+		for i := 0; i < cap(done); i++ {
+			go func() {
+				addr := c.LocalAddr()
+				if addr == nil {
+					done <- ""
+					return
+				}
+				done <- addr.String()
+			}()
+		}
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	c, err := net.Dial("tcp", "127.0.0.1:8920")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < cap(done); i++ {
+		select {
+		case addr := <-done:
+			if addr != "127.0.0.1:8920" {
+				t.Fatalf("invalid local addr: %q", addr)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("LocalAddr timeout")
+		}
+	}
+}
+
+func TestAcceptInPoller(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8892", func(t *testing.T, conf Config) {
+		conf.AcceptInPoller = true
+		conf.NPoller = 2
+		testEchoServer(t, conf)
+	})
+}
+
+func TestUDPEcho(t *testing.T) {
+	forEachIOMod(t, "udp", "127.0.0.1:8894", testUDPEcho)
+}
+
+func testUDPEcho(t *testing.T, conf Config) {
+	addr := conf.Addrs[0]
+	g := NewGopher(conf)
+	g.OnPacket(func(c *PacketConn, from net.Addr, data []byte) {
+		c.WriteTo(data, from)
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("packet-%v", i))
+		conn.Write(msg)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if string(buf[:n]) != string(msg) {
+			t.Fatalf("invalid echo: %q, want: %q", buf[:n], msg)
+		}
+	}
+}
+
+func TestUDPTruncated(t *testing.T) {
+	forEachIOMod(t, "udp", "127.0.0.1:8921", testUDPTruncated)
+}
+
+func testUDPTruncated(t *testing.T, conf Config) {
+	addr := conf.Addrs[0]
+	conf.ReadBufferSize = 1024
+	g := NewGopher(conf)
+	g.OnPacket(func(c *PacketConn, from net.Addr, data []byte) {
+		c.WriteTo(data, from)
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// the long datagram is dropped, only the short one is echoed.
+	conn.Write(make([]byte, 2048))
+	conn.Write([]byte("short"))
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf[:n]) != "short" {
+		t.Fatalf("invalid echo: %v bytes, want: %q", n, "short")
+	}
+}
+
+func TestUnixEcho(t *testing.T) {
+	t.Run("unixpacket", func(t *testing.T) {
+		forEachIOMod(t, "unixpacket", filepath.Join(os.TempDir(), "easynet_packet_test.sock"), testUnixEcho)
+	})
+	t.Run("abstract", func(t *testing.T) {
+		forEachIOMod(t, "unix", "@easynet_abstract_test", testUnixEcho)
+	})
+}
+
+func testUnixEcho(t *testing.T, conf Config) {
+	network, addr := conf.Network, conf.Addrs[0]
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write(append([]byte{}, data...))
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		msg := []byte(fmt.Sprintf("message-%v", i))
+		conn.Write(msg)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		// a unixpacket read returns exactly one message.
+		n, err := io.ReadAtLeast(conn, buf, len(msg))
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if string(buf[:n]) != string(msg) {
+			t.Fatalf("invalid echo: %q, want: %q", buf[:n], msg)
+		}
+	}
+
+	if addr[0] == '@' {
+		if _, err := os.Stat(addr); err == nil {
+			t.Fatalf("abstract addr created a socket file: %v", addr)
+		}
+	}
+}
+
+func TestUnixgramUnsupported(t *testing.T) {
+	g := NewGopher(Config{
+		Network: "unixgram",
+		Addrs:   []string{filepath.Join(os.TempDir(), "easynet_gram_test.sock")},
+	})
+	err := g.Start()
+	if err != errUnixgramUnsupported {
+		g.Stop()
+		t.Fatalf("invalid Start error: %v", err)
+	}
+}
+
+func TestUnixFdPassing(t *testing.T) {
+	forEachIOMod(t, "unix", filepath.Join(os.TempDir(), "easynet_test.sock"), testUnixFdPassing)
+}
+
+func testUnixFdPassing(t *testing.T, conf Config) {
+	addr := conf.Addrs[0]
+
+	// a stale socket file left by a crashed process.
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		log.Panicf("Listen failed: %v", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	chFd := make(chan int, 1)
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		for _, fd := range c.ReadFds() {
+			chFd <- fd
+		}
+	})
+	err = g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v", err)
+	}
+
+	c, err := Dial("unix", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	g.AddConn(c)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Panicf("Pipe failed: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if _, err = c.WriteFds([]byte{1}, int(r.Fd())); err != nil {
+		t.Fatalf("WriteFds failed: %v", err)
+	}
+
+	var fd int
+	select {
+	case fd = <-chFd:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("fd not received")
+	}
+	defer syscall.Close(fd)
+
+	w.Write([]byte("hello"))
+	buf := make([]byte, 16)
+	n, err := syscall.Read(fd, buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("read passed fd failed: %v, %q", err, buf[:n])
+	}
+
+	g.Stop()
+	if _, err = os.Lstat(addr); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed: %v", err)
+	}
+}
+
+func TestEpollMod(t *testing.T) {
+	testEchoServer(t, Config{Network: "tcp", Addrs: []string{"127.0.0.1:8896"}, EpollMod: EPOLLLT})
+	testEchoServer(t, Config{Network: "tcp", Addrs: []string{"127.0.0.1:8897"}, EpollMod: EPOLLET})
+	testEchoServer(t, Config{Network: "tcp", Addrs: []string{"127.0.0.1:8898"}, EpollMod: EPOLLET, MaxReadTimesPerEventLoop: 1, ReadBufferSize: 64})
+}
+
+func TestEpollETOnRead(t *testing.T) {
+	var msgSize = 1024 * 64
+	var total int64 = 0
+	var done = make(chan int)
+
+	g := NewGopher(Config{
+		Network:  "tcp",
+		Addrs:    []string{"127.0.0.1:8899"},
+		EpollMod: EPOLLET,
+	})
+	g.OnRead(func(c *Conn) {
+		buf := make([]byte, 1024)
+		for {
+			n, err := c.Read(buf)
+			if n > 0 && atomic.AddInt64(&total, int64(n)) == int64(msgSize) {
+				close(done)
+			}
+			if errors.Is(err, syscall.EAGAIN) {
+				return
+			}
+			if err != nil || n == 0 {
+				c.CloseWithError(err)
+				return
+			}
+		}
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8899")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write(make([]byte, msgSize))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("read timeout, recved: %v, want: %v", atomic.LoadInt64(&total), msgSize)
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	testBalancer(t, NewRoundRobinBalancer(), []int{2, 2, 2, 2})
+	testBalancer(t, NewLeastConnBalancer(), []int{2, 2, 2, 2})
+	testBalancer(t, NewIPHashBalancer(), nil)
+}
+
+func testBalancer(t *testing.T, b Balancer, want []int) {
+	addr := "127.0.0.1:8900"
+	g := NewGopher(Config{
+		Network:  "tcp",
+		Addrs:    []string{addr},
+		NPoller:  4,
+		Balancer: b,
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+	}
+	var conns []int
+	for i := 0; i < 100; i++ {
+		conns = g.PollerConns()
+		if conns[0]+conns[1]+conns[2]+conns[3] == 8 {
+			break
+		}
+		time.Sleep(time.Second / 100)
+	}
+
+	if want == nil {
+		// all conns come from the same ip.
+		sort.Ints(conns)
+		want = []int{0, 0, 0, 8}
+	}
+	if fmt.Sprint(conns) != fmt.Sprint(want) {
+		t.Fatalf("invalid poller conns: %v, want: %v", conns, want)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	g := NewGopher(Config{
+		NWorker:         1,
+		WorkerQueueSize: 1,
+		WorkerOverflow:  WorkerOverflowDrop,
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	chBlock := make(chan struct{})
+	chRunning := make(chan struct{})
+	g.Execute(func() {
+		close(chRunning)
+		<-chBlock
+	})
+	<-chRunning
+
+	g.Execute(func() {
+		panic("test")
+	})
+	var executed int32
+	for i := 0; i < 2; i++ {
+		g.Execute(func() {
+			atomic.AddInt32(&executed, 1)
+		})
+	}
+	stats := g.WorkerStats()
+	if stats.Busy != 1 || stats.QueueLen != 1 || stats.Dropped != 2 {
+		t.Fatalf("invalid worker stats: %+v", stats)
+	}
+	close(chBlock)
+
+	for i := 0; i < 100 && g.WorkerStats().QueueLen > 0; i++ {
+		time.Sleep(time.Second / 100)
+	}
+	done := make(chan struct{})
+	g.Execute(func() {
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("worker stopped after panic, stats: %+v", g.WorkerStats())
+	}
+	if n := atomic.LoadInt32(&executed); n != 0 {
+		t.Fatalf("invalid executed num: %v", n)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8901", testShutdown)
+}
+
+func testShutdown(t *testing.T, conf Config) {
+	var msgSize = 1024 * 1024 * 8
+	var shutdownCalled int32
+
+	conf.NWorker = 1
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		c.Execute(func() {
+			c.Write(make([]byte, msgSize))
+		})
+	})
+	g.OnShutdown(func() {
+		atomic.StoreInt32(&shutdownCalled, 1)
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8901")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 1024*64)
+	total, err := conn.Read(buf)
+	if err != nil {
+		log.Panicf("Read failed: %v", err)
+	}
+
+	chShutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		chShutdown <- g.Shutdown(ctx)
+	}()
+
+	for {
+		n, err := conn.Read(buf)
+		total += n
+		if err != nil {
+			break
+		}
+	}
+	if total != msgSize {
+		t.Fatalf("invalid recved size: %v, want: %v", total, msgSize)
+	}
+	if err = <-chShutdown; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if atomic.LoadInt32(&shutdownCalled) != 1 {
+		t.Fatalf("OnShutdown not called")
+	}
+	if _, err = net.Dial("tcp", "127.0.0.1:8901"); err == nil {
+		t.Fatalf("Dial succeeded after Shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"127.0.0.1:8902"},
+	})
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write(make([]byte, 1024*1024*32))
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8902")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	conn.Read(make([]byte, 1024))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+	if err = g.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("invalid Shutdown error: %v", err)
+	}
+}
+
+func TestPassListeners(t *testing.T) {
+	var addr = "127.0.0.1:8903"
+	if os.Getenv("EASYNET_TEST_CHILD") != "" {
+		testPassListenersChild(addr)
+		return
+	}
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{addr},
+	})
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write([]byte("parent"))
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPassListeners$")
+	cmd.Env = append(os.Environ(), "EASYNET_TEST_CHILD=1")
+	cmd.Stderr = os.Stderr
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err = g.PassListeners(cmd); err != nil {
+		t.Fatalf("PassListeners failed: %v", err)
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("start child failed: %v", err)
+	}
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	ready := false
+	scanner := bufio.NewScanner(stdout)
+	for !ready && scanner.Scan() {
+		ready = scanner.Text() == "ready"
+	}
+	if !ready {
+		t.Fatalf("child not ready: %v", scanner.Err())
+	}
+	go io.Copy(io.Discard, stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = g.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.Write([]byte("hello"))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		n, err := conn.Read(buf)
+		conn.Close()
+		if err != nil || string(buf[:n]) != "child" {
+			t.Fatalf("invalid response: %v, %v", string(buf[:n]), err)
+		}
+	}
+}
+
+func testPassListenersChild(addr string) {
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{addr},
+	})
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write([]byte("child"))
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	os.Stdout.Write([]byte("ready\n"))
+	os.Stdin.Read(make([]byte, 1))
+}
+
+func TestSystemdListeners(t *testing.T) {
+	addrs := []string{"127.0.0.1:8904", "127.0.0.1:8905"}
+	for i, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Panicf("Listen failed: %v", err)
+		}
+		f, _ := ln.(*net.TCPListener).File()
+		if err = syscall.Dup2(int(f.Fd()), 100+len(addrs)-1-i); err != nil {
+			log.Panicf("Dup2 failed: %v", err)
+		}
+		f.Close()
+		ln.Close()
+	}
+
+	savedStart := listenFdsStart
+	listenFdsStart = 100
+	defer func() {
+		listenFdsStart = savedStart
+	}()
+	os.Setenv("LISTEN_PID", fmt.Sprintf("%v", os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "second:unknown")
+
+	g := NewGopher(Config{
+		Network:       "tcp",
+		Addrs:         addrs,
+		ListenerNames: map[string]string{"second": addrs[1]},
+	})
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write(append([]byte{}, data...))
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatalf("LISTEN_FDS not unset")
+	}
+	for i, l := range g.listeners {
+		if l.lfd != 100+len(addrs)-1-i {
+			t.Fatalf("listener %v not adopted: %v", addrs[i], l.lfd)
+		}
+	}
+
+	buf := make([]byte, 64)
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.Write([]byte("hello"))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		n, err := conn.Read(buf)
+		conn.Close()
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("invalid response: %v, %v", string(buf[:n]), err)
+		}
+	}
+}
+
+func TestSystemdListenersShared(t *testing.T) {
+	addrs := []string{"127.0.0.1:8922", "127.0.0.1:8923", "127.0.0.1:8924"}
+	for i, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Panicf("Listen failed: %v", err)
+		}
+		f, _ := ln.(*net.TCPListener).File()
+		if err = syscall.Dup2(int(f.Fd()), 100+i); err != nil {
+			log.Panicf("Dup2 failed: %v", err)
+		}
+		f.Close()
+		ln.Close()
+	}
+
+	savedStart := listenFdsStart
+	listenFdsStart = 100
+	defer func() {
+		listenFdsStart = savedStart
+	}()
+	os.Setenv("LISTEN_PID", fmt.Sprintf("%v", os.Getpid()))
+	os.Setenv("LISTEN_FDS", "3")
+	os.Setenv("LISTEN_FDNAMES", "first:second:third")
+
+	// each Gopher adopts its own fd, the one wanted by nobody is kept open until CloseInheritedListeners.
+	for i, addr := range addrs[:2] {
+		g := NewGopher(Config{
+			Network:       "tcp",
+			Addrs:         []string{addr},
+			ListenerNames: map[string]string{"second": addrs[1]},
+		})
+		err := g.Start()
+		if err != nil {
+			log.Panicf("Start failed: %v\n", err)
+		}
+		defer g.Stop()
+
+		if g.listeners[0].lfd != 100+i {
+			t.Fatalf("listener %v not adopted: %v", addr, g.listeners[0].lfd)
+		}
+	}
+
+	if _, err := syscall.GetsockoptInt(102, syscall.SOL_SOCKET, syscall.SO_TYPE); err != nil {
+		t.Fatalf("unadopted listener closed: %v", err)
+	}
+	CloseInheritedListeners()
+	if _, err := syscall.GetsockoptInt(102, syscall.SOL_SOCKET, syscall.SO_TYPE); err != syscall.EBADF {
+		t.Fatalf("unadopted listener not closed: %v", err)
+	}
+}
+
+func TestSystemdListenersAdopted(t *testing.T) {
+	udpAddr := "127.0.0.1:8927"
+	unixAddr := filepath.Join(os.TempDir(), "easynet_systemd_test.sock")
+	os.Remove(unixAddr)
+
+	pc, err := net.ListenPacket("udp", udpAddr)
+	if err != nil {
+		log.Panicf("ListenPacket failed: %v", err)
+	}
+	f, _ := pc.(*net.UDPConn).File()
+	if err = syscall.Dup2(int(f.Fd()), 100); err != nil {
+		log.Panicf("Dup2 failed: %v", err)
+	}
+	f.Close()
+	pc.Close()
+
+	ln, err := net.Listen("unix", unixAddr)
+	if err != nil {
+		log.Panicf("Listen failed: %v", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	f, _ = ln.(*net.UnixListener).File()
+	if err = syscall.Dup2(int(f.Fd()), 101); err != nil {
+		log.Panicf("Dup2 failed: %v", err)
+	}
+	f.Close()
+	ln.Close()
+	defer os.Remove(unixAddr)
+
+	savedStart := listenFdsStart
+	listenFdsStart = 100
+	defer func() {
+		listenFdsStart = savedStart
+	}()
+	os.Setenv("LISTEN_PID", fmt.Sprintf("%v", os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "dns:ctl")
+
+	// the unix Gopher is started first, the udp fd is left to the udp Gopher.
+	gUnix := NewGopher(Config{
+		Network:       "unix",
+		Addrs:         []string{unixAddr},
+		ListenerNames: map[string]string{"ctl": unixAddr},
+	})
+	err = gUnix.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	if gUnix.listeners[0].lfd != 101 {
+		t.Fatalf("unix listener not adopted: %v", gUnix.listeners[0].lfd)
+	}
+
+	gUDP := NewGopher(Config{
+		Network: "udp",
+		Addrs:   []string{udpAddr},
+	})
+	gUDP.OnPacket(func(c *PacketConn, from net.Addr, data []byte) {
+		c.WriteTo(data, from)
+	})
+	err = gUDP.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer gUDP.Stop()
+	if gUDP.packetConns[0].fd != 100 {
+		t.Fatalf("udp socket not adopted: %v", gUDP.packetConns[0].fd)
+	}
+
+	conn, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("invalid response: %v, %v", string(buf[:n]), err)
+	}
+
+	gUnix.Stop()
+	if _, err = os.Stat(unixAddr); err != nil {
+		t.Fatalf("adopted unix socket file removed: %v", err)
+	}
+}
+
+func TestPassListenersMerge(t *testing.T) {
+	addrs := []string{"127.0.0.1:8925", "127.0.0.1:8926"}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = []string{"A=1"}
+	for _, addr := range addrs {
+		g := NewGopher(Config{
+			Network: "tcp",
+			Addrs:   []string{addr},
+		})
+		err := g.Start()
+		if err != nil {
+			log.Panicf("Start failed: %v\n", err)
+		}
+		defer g.Stop()
+
+		if err = g.PassListeners(cmd); err != nil {
+			t.Fatalf("PassListeners failed: %v", err)
+		}
+	}
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+
+	want := []string{"A=1", fmt.Sprintf("%v=%v=3;%v=4", ListenerFdsEnv, addrs[0], addrs[1])}
+	if strings.Join(cmd.Env, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("invalid env: %v, want: %v", cmd.Env, want)
+	}
+}
+
+func TestConnTable(t *testing.T) {
+	table := newConnTable()
+	if table.get(100000) != nil || table.len() != 0 {
+		t.Fatalf("invalid empty table")
+	}
+
+	fds := []int{3, 4, connTablePageSize * 3, connTablePageSize*3 + 1}
+	for _, fd := range fds {
+		table.set(fd, &Conn{fd: fd})
+	}
+	if n := len(table.pages.Load().([]*connPage)); n != 4 {
+		t.Fatalf("invalid page num: %v", n)
+	}
+	for _, fd := range fds {
+		if c := table.get(fd); c == nil || c.fd != fd {
+			t.Fatalf("invalid conn of fd %v: %v", fd, c)
+		}
+	}
+
+	if table.delete(4, &Conn{fd: 4}) {
+		t.Fatalf("deleted a conn not in table")
+	}
+	if !table.delete(4, table.get(4)) || table.get(4) != nil {
+		t.Fatalf("delete failed")
+	}
+	if table.len() != len(fds)-1 {
+		t.Fatalf("invalid len: %v", table.len())
+	}
+
+	var ranged []int
+	table.rangeConns(func(c *Conn) bool {
+		ranged = append(ranged, c.fd)
+		return true
+	})
+	if len(ranged) != 3 || ranged[0] != 3 || ranged[1] != connTablePageSize*3 || ranged[2] != connTablePageSize*3+1 {
+		t.Fatalf("invalid range result: %v", ranged)
+	}
+}
+
+func TestConnTableRelease(t *testing.T) {
+	table := newConnTable()
+	pageNum := func() (n int, size int) {
+		pages := table.pages.Load().([]*connPage)
+		for _, page := range pages {
+			if page != nil {
+				n++
+			}
+		}
+		return n, len(pages)
+	}
+
+	table.set(3, &Conn{fd: 3})
+	var conns []*Conn
+	for _, fd := range []int{connTablePageSize * 2, connTablePageSize*5 + 1, connTablePageSize * 9} {
+		c := &Conn{fd: fd}
+		table.set(fd, c)
+		conns = append(conns, c)
+	}
+	if n, size := pageNum(); n != 4 || size != 10 {
+		t.Fatalf("invalid pages: %v, %v", n, size)
+	}
+
+	// a page in the middle is released, the trailing ones are trimmed.
+	table.delete(conns[1].fd, conns[1])
+	if n, size := pageNum(); n != 3 || size != 10 {
+		t.Fatalf("invalid pages after releasing a middle page: %v, %v", n, size)
+	}
+	table.delete(conns[2].fd, conns[2])
+	if n, size := pageNum(); n != 2 || size != 3 {
+		t.Fatalf("invalid pages after releasing the last page: %v, %v", n, size)
+	}
+	table.delete(conns[0].fd, conns[0])
+	if n, size := pageNum(); n != 1 || size != 1 {
+		t.Fatalf("invalid pages after releasing all high fds: %v, %v", n, size)
+	}
+
+	if table.get(conns[1].fd) != nil || table.delete(conns[1].fd, conns[1]) {
+		t.Fatalf("conn found in a released page")
+	}
+	var ranged []int
+	table.rangeConns(func(c *Conn) bool {
+		ranged = append(ranged, c.fd)
+		return true
+	})
+	table.rangePages(0, 10, func(c *Conn) {
+		ranged = append(ranged, c.fd)
+	})
+	if len(ranged) != 2 || ranged[0] != 3 || ranged[1] != 3 {
+		t.Fatalf("invalid range result: %v", ranged)
+	}
+
+	// a released page is allocated again.
+	table.set(conns[1].fd, conns[1])
+	if table.get(conns[1].fd) != conns[1] || table.len() != 2 {
+		t.Fatalf("set failed after releasing the page")
+	}
+}
+
+func TestConnIDMap(t *testing.T) {
+	m := newConnIDMap()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for id := uint64(i*1000 + 1); id <= uint64(i*1000+1000); id++ {
+				m.set(id, &Conn{id: id})
+				if id%2 == 0 {
+					m.delete(id, m.get(id))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for id := uint64(1); id <= 8000; id++ {
+		c := m.get(id)
+		if id%2 == 0 && c != nil || id%2 == 1 && (c == nil || c.id != id) {
+			t.Fatalf("invalid conn of id %v: %v", id, c)
+		}
+	}
+	m.delete(1, &Conn{id: 1})
+	if m.get(1) == nil {
+		t.Fatalf("deleted a conn not in map")
+	}
+}
+
+func TestConnRange(t *testing.T) {
+	var clientNum = 4
+	var chOpen = make(chan *Conn, clientNum)
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"127.0.0.1:8906"},
+	})
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	for i := 0; i < clientNum; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:8906")
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+	}
+	ids := map[uint64]*Conn{}
+	for i := 0; i < clientNum; i++ {
+		c := <-chOpen
+		ids[c.ID()] = c
+	}
+	if len(ids) != clientNum || g.ConnCount() != clientNum {
+		t.Fatalf("invalid conn num: %v, %v", len(ids), g.ConnCount())
+	}
+
+	ranged := 0
+	g.Range(func(c *Conn) bool {
+		if ids[c.ID()] != c {
+			t.Fatalf("unknown conn: %v", c.ID())
+		}
+		ranged++
+		return true
+	})
+	if ranged != clientNum {
+		t.Fatalf("invalid range num: %v", ranged)
+	}
+
+	for id, c := range ids {
+		if g.ConnByID(id) != c {
+			t.Fatalf("ConnByID failed: %v", id)
+		}
+		c.Close()
+		if g.ConnByID(id) != nil {
+			t.Fatalf("closed conn found by id: %v", id)
+		}
+		break
+	}
+	if g.ConnCount() != clientNum-1 {
+		t.Fatalf("invalid conn num after close: %v", g.ConnCount())
+	}
+}
+
+func TestGroupBroadcast(t *testing.T) {
+	var clientNum = 3
+	var chOpen = make(chan *Conn, clientNum)
+	var chClose = make(chan *Conn, clientNum)
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"127.0.0.1:8907"},
+	})
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	g.OnClose(func(c *Conn, err error) {
+		chClose <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	codec := NewLengthFieldBasedFrameCodec(EncoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1}, DecoderConfig{})
+	gr := g.Group("room")
+	var clients []net.Conn
+	for i := 0; i < clientNum; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:8907")
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+		c := <-chOpen
+		if i > 0 {
+			c.SetCodec(codec)
+		}
+		gr.Join(c)
+	}
+
+	if n := gr.Broadcast([]byte("hello")); n != clientNum {
+		t.Fatalf("invalid broadcast num: %v", n)
+	}
+	for i, conn := range clients {
+		want := "hello"
+		if i > 0 {
+			want = "\x05hello"
+		}
+		buf := make([]byte, len(want))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != want {
+			t.Fatalf("invalid broadcast data: %q, %v", buf, err)
+		}
+	}
+
+	clients[0].Close()
+	<-chClose
+	if gr.Len() != clientNum-1 {
+		t.Fatalf("closed conn not removed from group: %v", gr.Len())
+	}
+
+	var slow *Conn
+	gr.Range(func(c *Conn) bool {
+		slow = c
+		return false
+	})
+	slow.Write(make([]byte, 1024*1024*32))
+	gr.SetSlowConsumerPolicy(SlowConsumerClose, 1024*1024)
+	if n := gr.Broadcast([]byte("hello")); n != clientNum-2 {
+		t.Fatalf("invalid broadcast num with slow consumer: %v", n)
+	}
+	if closed, err := slow.IsClosed(); !closed || !errors.Is(err, errSlowConsumer) {
+		t.Fatalf("slow consumer not closed: %v, %v", closed, err)
+	}
+	if gr.Len() != clientNum-2 {
+		t.Fatalf("slow consumer not removed from group: %v", gr.Len())
+	}
+}
+
+// connIDCodec is not comparable, Group.Broadcast calls it for every member with its own conn.
+type connIDCodec struct {
+	sep []byte
+}
+
+func (cc connIDCodec) Encode(c *Conn, buf []byte) ([]byte, error) {
+	return append(append([]byte(fmt.Sprintf("%v", c.ID())), cc.sep...), buf...), nil
+}
+
+func (cc connIDCodec) Decode(c *Conn) ([]byte, error) {
+	return nil, nil
+}
+
+func TestGroupBroadcastNonComparableCodec(t *testing.T) {
+	var clientNum = 2
+	var chOpen = make(chan *Conn, clientNum)
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"127.0.0.1:8928"},
+	})
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	gr := g.Group("room")
+	var clients []net.Conn
+	var wants []string
+	for i := 0; i < clientNum; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:8928")
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		clients = append(clients, conn)
+		c := <-chOpen
+		c.SetCodec(connIDCodec{sep: []byte(":")})
+		gr.Join(c)
+		wants = append(wants, fmt.Sprintf("%v:hello", c.ID()))
+	}
+
+	if n := gr.Broadcast([]byte("hello")); n != clientNum {
+		t.Fatalf("invalid broadcast num: %v", n)
+	}
+	for i, conn := range clients {
+		buf := make([]byte, len(wants[i]))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != wants[i] {
+			t.Fatalf("invalid broadcast data: %q, %v", buf, err)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8908", testStats)
+}
+
+func testStats(t *testing.T, conf Config) {
+	var chClose = make(chan struct{}, 1)
+
+	conf.NPoller = 2
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write(append([]byte{}, data...))
+	})
+	g.OnClose(func(c *Conn, err error) {
+		chClose <- struct{}{}
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8908")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	g.After(time.Hour)
+
+	st := g.Stats()
+	if st.ConnsAccepted != 1 || st.Conns != 1 || st.BytesRead != 5 || st.BytesWritten != 5 || st.PendingTimers != 1 {
+		t.Fatalf("invalid stats: %+v", st)
+	}
+	if len(st.PollerConns) != 2 || st.PollerConns[0]+st.PollerConns[1] != 1 || st.PollerWakeups[0]+st.PollerWakeups[1] == 0 {
+		t.Fatalf("invalid poller stats: %+v", st)
+	}
+
+	conn.Close()
+	<-chClose
+	if st = g.Stats(); st.ConnsClosed != 1 || st.Conns != 0 {
+		t.Fatalf("invalid stats after close: %+v", st)
+	}
+
+	w := httptest.NewRecorder()
+	g.StatsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE easynet_conns_accepted_total counter\n",
+		`easynet_conns_closed_total{gopher="NB"} 1` + "\n",
+		`easynet_read_bytes_total{gopher="NB"} 5` + "\n",
+		`easynet_poller_conns{gopher="NB",poller="1"} 0` + "\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("%q not found in:\n%v", line, body)
+		}
+	}
+}
+
+func TestStatsPrometheusLabel(t *testing.T) {
+	body := string(Stats{}.prometheus("a\"b\\c\nd\u00e9"))
+	line := `easynet_conns{gopher="a\"b\\c\nd` + "\u00e9" + `"} 0` + "\n"
+	if !strings.Contains(body, line) {
+		t.Fatalf("%q not found in:\n%v", line, body)
+	}
+}
+
+func TestStatsCacheBuffer(t *testing.T) {
+	var chOpen = make(chan *Conn, 1)
+
+	g := NewGopher(Config{
+		Network: "tcp",
+		Addrs:   []string{"127.0.0.1:8929"},
+	})
+	g.OnOpen(func(c *Conn) {
+		c.SetCodec(NewLengthFieldBasedFrameCodec(EncoderConfig{}, DecoderConfig{ByteOrder: binary.BigEndian, LengthFieldLength: 1, InitialBytesToStrip: 1}))
+		chOpen <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8929")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	<-chOpen
+
+	// a frame of 10 bytes with 3 bytes of it sent stays in the codec cache.
+	conn.Write([]byte("\x0aabc"))
+	for i := 0; i < 100 && g.Stats().CacheBufferBytes == 0; i++ {
+		time.Sleep(time.Second / 100)
+	}
+	if n := g.Stats().CacheBufferBytes; n == 0 || n > 4 {
+		t.Fatalf("invalid CacheBufferBytes: %v", n)
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8909", testConnStats)
+}
+
+func testConnStats(t *testing.T, conf Config) {
+	var chStats = make(chan ConnStats, 1)
+
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		c.Write(make([]byte, 1024*1024*8))
+		chStats <- c.Stats()
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	begin := time.Now()
+	conn, err := net.Dial("tcp", "127.0.0.1:8909")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+
+	st := <-chStats
+	if st.BytesRead != 5 || st.FramesRead != 1 || st.FramesWritten != 1 || st.PartialWrites != 1 {
+		t.Fatalf("invalid conn stats: %+v", st)
+	}
+	if st.WriteBufferLen == 0 || st.PeakWriteBufferLen != st.WriteBufferLen || int(st.BytesWritten)+st.WriteBufferLen != 1024*1024*8 {
+		t.Fatalf("invalid write buffer stats: %+v", st)
+	}
+	if st.OpenTime.Before(begin) || st.LastReadTime.Before(st.OpenTime) || st.LastWriteTime.Before(st.LastReadTime) {
+		t.Fatalf("invalid conn times: %+v", st)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	var chOpen = make(chan *Conn, 3)
+	var chClose = make(chan *Conn, 3)
+	// the conns left are closed by the client and Stop after checking.
+	var checking int32 = 1
+
+	g := NewGopher(Config{
+		Network:     "tcp",
+		Addrs:       []string{"127.0.0.1:8910"},
+		IdleTimeout: time.Second / 2,
+	})
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	g.OnClose(func(c *Conn, err error) {
+		if atomic.LoadInt32(&checking) == 0 {
+			return
+		}
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("invalid close error: %v", err)
+		}
+		chClose <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	var conns []net.Conn
+	var cs []*Conn
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:8910")
+		if err != nil {
+			log.Panicf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		cs = append(cs, <-chOpen)
+	}
+	idle, disabled := cs[0], cs[2]
+	disabled.SetIdleTimeout(-1)
+
+	var closed []*Conn
+	timeout := time.After(time.Second * 2)
+	ticker := time.NewTicker(time.Second / 10)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case c := <-chClose:
+			if d := time.Since(time.Unix(0, c.lastActive())); d < time.Second/2 {
+				t.Fatalf("closed too early: %v", d)
+			}
+			closed = append(closed, c)
+		case <-ticker.C:
+			conns[1].Write([]byte("ping"))
+		case <-timeout:
+			done = true
+		}
+	}
+	atomic.StoreInt32(&checking, 0)
+	if len(closed) != 1 || closed[0] != idle {
+		t.Fatalf("invalid closed conns: %v", len(closed))
+	}
+}
+
+func TestIdleSweepStop(t *testing.T) {
+	g := NewGopher(Config{IdleTimeout: time.Second})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	g.Stop()
+
+	// a sweep running while stopping does not re-arm itself, and a conn can not start it again.
+	n := g.pendingTimers()
+	g.sweepIdleConns()
+	g.startIdleSweep()
+	if g.pendingTimers() != n {
+		t.Fatalf("idle sweeper re-armed after Stop: %v, %v", n, g.pendingTimers())
+	}
+}
+
+func TestEvery(t *testing.T) {
+	g := NewGopher(Config{})
+	g.Start()
+	defer g.Stop()
+
+	interval := time.Second / 10
+	for _, mode := range []int{TickFixedRate, TickFixedDelay} {
+		begin := time.Now()
+		chFired := make(chan time.Duration, 2)
+		n := 0
+		pt := g.EveryWithMode(interval, mode, func() {
+			chFired <- time.Since(begin)
+			n++
+			if n == 1 {
+				time.Sleep(interval * 5 / 2)
+			}
+		})
+		<-chFired
+		second := <-chFired
+		pt.Stop()
+
+		// the first call returns at 3.5 intervals.
+		want := interval * 4
+		if mode == TickFixedDelay {
+			want = interval * 9 / 2
+		}
+		if second < want || second > want+interval/2 {
+			t.Fatalf("invalid second tick of mode %v: %v, want: %v", mode, second, want)
+		}
+	}
+
+	ticker := g.Ticker(interval / 2)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ticker.C:
+		case <-time.After(time.Second):
+			t.Fatalf("ticker timeout")
+		}
+	}
+	ticker.Stop()
+	select {
+	case <-ticker.C:
+	default:
+	}
+	select {
+	case <-ticker.C:
+		t.Fatalf("tick after Stop")
+	case <-time.After(interval):
+	}
+}
+
+func TestPollerTimers(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8911", testPollerTimers)
+	forEachIOMod(t, "tcp", "127.0.0.1:8913", func(t *testing.T, conf Config) {
+		conf.TimerEngine = TimerEngineWheel
+		testPollerTimers(t, conf)
+	})
+}
+
+func testPollerTimers(t *testing.T, conf Config) {
+	var chClose = make(chan error, 1)
+
+	addr := conf.Addrs[0]
+	conf.NPoller = 1
+	g := NewGopher(conf)
+	g.OnOpen(func(c *Conn) {
+		c.SetReadDeadline(time.Now().Add(time.Second / 10))
+	})
+	g.OnClose(func(c *Conn, err error) {
+		chClose <- err
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	// a slow Gopher timer must not delay the deadlines and OnClose of conns.
+	chBlocked := make(chan struct{})
+	g.AfterFunc(0, func() {
+		close(chBlocked)
+		time.Sleep(time.Second)
+	})
+	<-chBlocked
+
+	begin := time.Now()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-chClose:
+		if err != errReadTimeout {
+			t.Fatalf("invalid close error: %v", err)
+		}
+		if d := time.Since(begin); d < time.Second/10 || d > time.Second/2 {
+			t.Fatalf("invalid deadline: %v", d)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("deadline timeout")
+	}
+	if n := g.Stats().PendingTimers; n != 0 {
+		t.Fatalf("invalid pending timers: %v", n)
+	}
+}
+
+func TestWriteBufferWatermarkClamp(t *testing.T) {
+	g := NewGopher(Config{
+		MaxWriteBufferSize:       1000,
+		WriteBufferHighWatermark: 2000,
+		WriteBufferLowWatermark:  1500,
+	})
+	if g.writeHighWatermark != 500 || g.writeLowWatermark != 250 {
+		t.Fatalf("invalid watermarks: %v, %v", g.writeHighWatermark, g.writeLowWatermark)
+	}
+}
+
+func TestWriteBufferWatermark(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8914", testWriteBufferWatermark)
+}
+
+func testWriteBufferWatermark(t *testing.T, conf Config) {
+	var paused int32
+	var chHigh = make(chan *Conn, 1)
+	var chLow = make(chan *Conn, 1)
+
+	conf.WriteBufferHighWatermark = 1024 * 256
+	g := NewGopher(conf)
+	g.OnOpen(func(c *Conn) {
+		go func() {
+			data := make([]byte, 1024*16)
+			for atomic.LoadInt32(&paused) == 0 {
+				if _, err := c.Write(data); err != nil {
+					return
+				}
+			}
+		}()
+	})
+	g.OnWriteBufferHigh(func(c *Conn) {
+		atomic.StoreInt32(&paused, 1)
+		c.mux.Lock()
+		n := len(c.writeBuffer)
+		c.mux.Unlock()
+		if n < 1024*256 {
+			t.Errorf("invalid write buffer at high watermark: %v", n)
+		}
+		chHigh <- c
+	})
+	g.OnWriteBufferLow(func(c *Conn) {
+		if atomic.LoadInt32(&paused) == 0 {
+			t.Errorf("OnWriteBufferLow before OnWriteBufferHigh")
+		}
+		c.mux.Lock()
+		n := len(c.writeBuffer)
+		c.mux.Unlock()
+		if n > 1024*128 {
+			t.Errorf("invalid write buffer at low watermark: %v", n)
+		}
+		chLow <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8914")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-chHigh:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("OnWriteBufferHigh timeout")
+	}
+
+	chDone := make(chan struct{})
+	defer close(chDone)
+	go func() {
+		buf := make([]byte, 1024*64)
+		for {
+			select {
+			case <-chDone:
+				return
+			default:
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second / 10))
+			conn.Read(buf)
+		}
+	}()
+
+	select {
+	case <-chLow:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("OnWriteBufferLow timeout")
+	}
+}
+
+func TestPauseRead(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8915", testPauseRead)
+	testPauseRead(t, Config{Network: "tcp", Addrs: []string{"127.0.0.1:8916"}, EpollMod: EPOLLET})
+}
+
+func testPauseRead(t *testing.T, conf Config) {
+	var chData = make(chan []byte, 16)
+
+	addr := conf.Addrs[0]
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		if err := c.PauseRead(); err != nil {
+			t.Errorf("PauseRead failed: %v", err)
+		}
+		// a paused conn is still writable.
+		c.Write(append([]byte{}, data...))
+		chData <- append([]byte{}, data...)
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	var c *Conn
+	conn.Write([]byte("hello"))
+	select {
+	case <-chData:
+		g.Range(func(conn *Conn) bool {
+			c = conn
+			return false
+		})
+	case <-time.After(time.Second):
+		t.Fatalf("read timeout")
+	}
+	if !c.IsReadPaused() {
+		t.Fatalf("conn not paused")
+	}
+
+	conn.Write([]byte("world"))
+	select {
+	case data := <-chData:
+		t.Fatalf("read while paused: %q", data)
+	case <-time.After(time.Second / 5):
+	}
+
+	c.Execute(func() {
+		if err := c.ResumeRead(); err != nil {
+			t.Errorf("ResumeRead failed: %v", err)
+		}
+	})
+	select {
+	case data := <-chData:
+		if string(data) != "world" {
+			t.Fatalf("invalid data: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read timeout after ResumeRead")
+	}
+
+	buf := make([]byte, 10)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "helloworld" {
+		t.Fatalf("invalid echo: %q, %v", buf, err)
+	}
+}
+
+func TestPauseReadBeforeAdd(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8930", testPauseReadBeforeAdd)
+}
+
+func testPauseReadBeforeAdd(t *testing.T, conf Config) {
+	var chData = make(chan []byte, 1)
+
+	addr := conf.Addrs[0]
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Panicf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	conf.Addrs = nil
+	conf.NPoller = 1
+	g := NewGopher(conf)
+	g.OnData(func(c *Conn, data []byte) {
+		chData <- append([]byte{}, data...)
+	})
+	err = g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	peer, err := ln.Accept()
+	if err != nil {
+		log.Panicf("Accept failed: %v", err)
+	}
+	defer peer.Close()
+	peer.Write([]byte("hello"))
+
+	if err := c.PauseRead(); err != nil {
+		t.Fatalf("PauseRead failed: %v", err)
+	}
+	wakeups := g.Stats().PollerWakeups[0]
+	g.AddConn(c)
+
+	// a level triggered poller with the read interest of the readable fd registered wakes up all the time.
+	select {
+	case data := <-chData:
+		t.Fatalf("read while paused: %q", data)
+	case <-time.After(time.Second / 5):
+	}
+	if n := g.Stats().PollerWakeups[0] - wakeups; n > 10 {
+		t.Fatalf("poller spins on a paused conn: %v wakeups", n)
+	}
+
+	if err := c.ResumeRead(); err != nil {
+		t.Fatalf("ResumeRead failed: %v", err)
+	}
+	select {
+	case data := <-chData:
+		if string(data) != "hello" {
+			t.Fatalf("invalid data: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read timeout after ResumeRead")
+	}
+}
+
+func TestWriteCallback(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8918", testWriteCallback)
+}
+
+func testWriteCallback(t *testing.T, conf Config) {
+	var chOpen = make(chan *Conn, 2)
+
+	g := NewGopher(conf)
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8918")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	c := <-chOpen
+
+	// written at once.
+	chWritten := make(chan error, 2)
+	c.WriteWithCallback([]byte("hello"), func(err error) {
+		chWritten <- err
+	})
+	if err := <-chWritten; err != nil {
+		t.Fatalf("invalid callback error: %v", err)
+	}
+
+	// buffered until the peer reads.
+	size := 1024 * 1024 * 8
+	chDrained := make(chan struct{}, 1)
+	c.WriteWithCallback(make([]byte, size), func(err error) {
+		chWritten <- err
+	})
+	c.OnWriteDrained(func() {
+		chDrained <- struct{}{}
+	})
+	if c.writeBufferLen() == 0 {
+		t.Fatalf("write not buffered")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+	if err := c.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("invalid Flush error: %v", err)
+	}
+	select {
+	case <-chWritten:
+		t.Fatalf("callback before written")
+	default:
+	}
+
+	go io.CopyN(io.Discard, conn, int64(len("hello")+size))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := <-chWritten; err != nil {
+		t.Fatalf("invalid callback error: %v", err)
+	}
+	select {
+	case <-chDrained:
+	case <-time.After(time.Second):
+		t.Fatalf("OnWriteDrained timeout")
+	}
+
+	// the pending callbacks get the close error.
+	conn2, err := net.Dial("tcp", "127.0.0.1:8918")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn2.Close()
+	c2 := <-chOpen
+	c2.WriteWithCallback(make([]byte, size), func(err error) {
+		chWritten <- err
+	})
+	c2.CloseWithError(io.ErrClosedPipe)
+	if err := <-chWritten; err != io.ErrClosedPipe {
+		t.Fatalf("invalid callback error: %v", err)
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	forEachIOMod(t, "tcp", "127.0.0.1:8919", testSendFile)
+}
+
+func testSendFile(t *testing.T, conf Config) {
+	var chOpen = make(chan *Conn, 2)
+	var chClose = make(chan error, 2)
+
+	g := NewGopher(conf)
+	g.OnOpen(func(c *Conn) {
+		chOpen <- c
+	})
+	g.OnClose(func(c *Conn, err error) {
+		chClose <- err
+	})
+	err := g.Start()
+	if err != nil {
+		log.Panicf("Start failed: %v\n", err)
+	}
+	defer g.Stop()
+
+	data := make([]byte, 1024*1024*8)
+	rand.Read(data)
+	f, err := os.CreateTemp("", "easynet-sendfile")
+	if err != nil {
+		log.Panicf("CreateTemp failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(data)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8919")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	c := <-chOpen
+
+	// the file range, the data written after it, and the file read by io.Copy.
+	chSent := make(chan error, 2)
+	c.Write([]byte("head"))
+	if _, err := c.SendFileWithCallback(f, 0, -1, func(err error) {
+		chSent <- err
+	}); err != nil {
+		t.Fatalf("SendFile failed: %v", err)
+	}
+	c.Write([]byte("tail"))
+	f.Seek(1024, io.SeekStart)
+	if n, err := io.Copy(c, io.LimitReader(f, 1024)); n != 1024 || err != nil {
+		t.Fatalf("io.Copy failed: %v, %v", n, err)
+	}
+	if off, _ := f.Seek(0, io.SeekCurrent); off != 2048 {
+		t.Fatalf("invalid file offset: %v", off)
+	}
+
+	want := append([]byte("head"), data...)
+	want = append(want, "tail"...)
+	want = append(want, data[1024:2048]...)
+	got := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("invalid data")
+	}
+	if err := <-chSent; err != nil {
+		t.Fatalf("invalid callback error: %v", err)
+	}
+
+	// a plain io.Copy goes through (*os.File).WriteTo, the file is still sent by one sendfile frame
+	// instead of a Write for every 32k read.
+	f.Seek(0, io.SeekStart)
+	frames := c.Stats().FramesWritten
+	if n, err := io.Copy(c, f); n != int64(len(data)) || err != nil {
+		t.Fatalf("io.Copy failed: %v, %v", n, err)
+	}
+	if n := c.Stats().FramesWritten - frames; n != 1 {
+		t.Fatalf("io.Copy not sent by sendfile: %v frames written", n)
+	}
+	got = make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("invalid data")
+	}
+
+	// the write deadline closes the conn if the peer does not read.
+	conn2, err := net.Dial("tcp", "127.0.0.1:8919")
+	if err != nil {
+		log.Panicf("Dial failed: %v", err)
+	}
+	defer conn2.Close()
+	c2 := <-chOpen
+	c2.SetWriteDeadline(time.Now().Add(time.Second / 5))
+	if _, err := c2.SendFileWithCallback(f, 0, -1, func(err error) {
+		chSent <- err
+	}); err != nil {
+		t.Fatalf("SendFile failed: %v", err)
+	}
+	select {
+	case err := <-chSent:
+		if err != errWriteTimeout {
+			t.Fatalf("invalid callback error: %v", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("write deadline timeout")
+	}
+	if err := <-chClose; err != errWriteTimeout {
+		t.Fatalf("invalid close error: %v", err)
+	}
+}
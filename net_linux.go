@@ -0,0 +1,234 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package easyNet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// syscall does not export SO_REUSEPORT on linux.
+	soReusePort = 0xf
+
+	// maxFdsPerMessage is the max fds received with one recvmsg on a unix conn.
+	maxFdsPerMessage = 16
+)
+
+// listenSocket creates a nonblocking listening socket with raw syscalls, so that accepted fds
+// can be used by pollers directly without going through net.Listener.
+func listenSocket(network, address string, backlog int, reusePort bool) (int, net.Addr, error) {
+	if isUnixNetwork(network) {
+		return listenUnix(network, address, backlog)
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	sotype := syscall.SOCK_STREAM | syscall.SOCK_CLOEXEC | syscall.SOCK_NONBLOCK
+	fd, family, err := inetSocket(network, tcpAddr.IP, sotype, syscall.IPPROTO_TCP)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	err = bindSocket(fd, family, network, tcpAddr.IP, tcpAddr.Port, tcpAddr.Zone, reusePort)
+	if err == nil {
+		err = syscall.Listen(fd, backlog)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return -1, nil, &net.OpError{Op: "listen", Net: network, Addr: tcpAddr, Err: err}
+	}
+
+	return fd, localAddr(fd, network), nil
+}
+
+// listenUnix creates a listening unix socket, a stale socket file left by a previous process is removed,
+// address beginning with '@' is in the linux abstract namespace.
+func listenUnix(network, address string, backlog int) (int, net.Addr, error) {
+	unixAddr, err := net.ResolveUnixAddr(network, address)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	sotype := syscall.SOCK_STREAM
+	if network == "unixpacket" {
+		sotype = syscall.SOCK_SEQPACKET
+	}
+	sotype |= syscall.SOCK_CLOEXEC | syscall.SOCK_NONBLOCK
+	fd, err := syscall.Socket(syscall.AF_UNIX, sotype, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	removeStaleUnixSocketFile(unixAddr.Name)
+	err = syscall.Bind(fd, &syscall.SockaddrUnix{Name: unixAddr.Name})
+	if err == nil {
+		err = syscall.Listen(fd, backlog)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return -1, nil, &net.OpError{Op: "listen", Net: network, Addr: unixAddr, Err: err}
+	}
+
+	return fd, unixAddr, nil
+}
+
+// removeStaleUnixSocketFile removes a unix socket file that nobody is listening on.
+func removeStaleUnixSocketFile(name string) {
+	if name == "" || name[0] == '@' {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", name, time.Second/10); err == nil {
+		conn.Close()
+		return
+	}
+	removeUnixSocketFile(name)
+}
+
+// removeUnixSocketFile removes a unix socket file, regular files are never removed.
+func removeUnixSocketFile(name string) {
+	if name == "" || name[0] == '@' {
+		return
+	}
+	if fi, err := os.Lstat(name); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(name)
+	}
+}
+
+// recvmsg reads data and the fds passed with SCM_RIGHTS.
+func recvmsg(fd int, b []byte, oob []byte) (int, []int, error) {
+	n, oobn, _, _, err := syscall.Recvmsg(fd, b, oob, syscall.MSG_CMSG_CLOEXEC)
+	if err != nil || oobn == 0 {
+		return n, nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return n, fds, nil
+}
+
+// listenPacket creates a nonblocking udp socket bound to address.
+func listenPacket(network, address string, reusePort bool) (int, int, net.Addr, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return -1, 0, nil, err
+	}
+
+	fd, family, err := inetSocket(network, udpAddr.IP, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.IPPROTO_UDP)
+	if err != nil {
+		return -1, 0, nil, err
+	}
+
+	err = bindSocket(fd, family, network, udpAddr.IP, udpAddr.Port, udpAddr.Zone, reusePort)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, 0, nil, &net.OpError{Op: "listen", Net: network, Addr: udpAddr, Err: err}
+	}
+
+	return fd, family, localAddr(fd, network), nil
+}
+
+// inetSocket creates a socket of the family decided by network and ip,
+// a wildcard "tcp"/"udp" addr uses a dual stack ipv6 socket if ipv6 is supported.
+func inetSocket(network string, ip net.IP, sotype int, proto int) (int, int, error) {
+	family := syscall.AF_INET6
+	switch network[len(network)-1] {
+	case '4':
+		family = syscall.AF_INET
+	case '6':
+	default:
+		if ip != nil && ip.To4() != nil {
+			family = syscall.AF_INET
+		}
+	}
+
+	fd, err := syscall.Socket(family, sotype, proto)
+	if errors.Is(err, syscall.EAFNOSUPPORT) && family == syscall.AF_INET6 && ip == nil {
+		family = syscall.AF_INET
+		fd, err = syscall.Socket(family, sotype, proto)
+	}
+	return fd, family, err
+}
+
+func bindSocket(fd int, family int, network string, ip net.IP, port int, zone string, reusePort bool) error {
+	err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err != nil {
+		return err
+	}
+	if reusePort {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1)
+		if err != nil {
+			return err
+		}
+	}
+	if family == syscall.AF_INET6 {
+		v6only := 0
+		if network[len(network)-1] == '6' {
+			v6only = 1
+		}
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v6only)
+		if err != nil {
+			return err
+		}
+	}
+
+	sa, err := addrToSockaddr(family, ip, port, zone)
+	if err != nil {
+		return err
+	}
+	return syscall.Bind(fd, sa)
+}
+
+// accept accepts a conn with accept4, the new fd is nonblocking and close-on-exec already.
+func accept(lfd int, network string, lAddr net.Addr) (*Conn, error) {
+	fd, sa, err := syscall.Accept4(lfd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		fd:    fd,
+		rAddr: sockaddrToAddr(network, sa),
+	}
+	switch a := lAddr.(type) {
+	case *net.TCPAddr:
+		// a wildcard listener's local addr differs among conns, it's got here so that LocalAddr needs no lock.
+		if a.IP.IsUnspecified() {
+			c.lAddr = localAddr(fd, network)
+		} else {
+			c.lAddr = lAddr
+		}
+	case *net.UnixAddr:
+		c.lAddr = lAddr
+		c.isUnix = true
+	}
+
+	return c, nil
+}
+
+func isTemporaryAcceptError(err error) bool {
+	switch err {
+	case syscall.EINTR, syscall.ECONNABORTED, syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM:
+		return true
+	}
+	return false
+}
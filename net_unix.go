@@ -10,6 +10,7 @@ package easyNet
 import (
 	"errors"
 	"net"
+	"strings"
 	"syscall"
 )
 
@@ -46,9 +47,87 @@ func dupStdConn(conn net.Conn) (*Conn, error) {
 		return nil, err
 	}
 
+	_, isUnix := conn.(*net.UnixConn)
+
 	return &Conn{
-		fd:    newFd,
-		lAddr: conn.LocalAddr(),
-		rAddr: conn.RemoteAddr(),
+		fd:     newFd,
+		lAddr:  conn.LocalAddr(),
+		rAddr:  conn.RemoteAddr(),
+		isUnix: isUnix,
 	}, nil
 }
+
+func sockaddrToAddr(network string, sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		if strings.HasPrefix(network, "udp") {
+			return &net.UDPAddr{IP: ip, Port: sa.Port}
+		}
+		return &net.TCPAddr{IP: ip, Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		if strings.HasPrefix(network, "udp") {
+			return &net.UDPAddr{IP: ip, Port: sa.Port, Zone: zoneToString(int(sa.ZoneId))}
+		}
+		return &net.TCPAddr{IP: ip, Port: sa.Port, Zone: zoneToString(int(sa.ZoneId))}
+	case *syscall.SockaddrUnix:
+		if network == "" {
+			network = "unix"
+		}
+		return &net.UnixAddr{Name: sa.Name, Net: network}
+	}
+	return nil
+}
+
+// isUnixNetwork returns true for the unix stream networks, unixgram is not one of them.
+func isUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixpacket"
+}
+
+func addrToSockaddr(family int, ip net.IP, port int, zone string) (syscall.Sockaddr, error) {
+	switch family {
+	case syscall.AF_INET:
+		sa := &syscall.SockaddrInet4{Port: port}
+		if ip != nil {
+			ip4 := ip.To4()
+			if ip4 == nil {
+				return nil, &net.AddrError{Err: "non-IPv4 address", Addr: ip.String()}
+			}
+			copy(sa.Addr[:], ip4)
+		}
+		return sa, nil
+	case syscall.AF_INET6:
+		sa := &syscall.SockaddrInet6{Port: port}
+		if ip != nil {
+			copy(sa.Addr[:], ip.To16())
+		}
+		if zone != "" {
+			if ifi, err := net.InterfaceByName(zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, nil
+	}
+	return nil, syscall.EAFNOSUPPORT
+}
+
+func zoneToString(zone int) string {
+	if zone == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(zone); err == nil {
+		return ifi.Name
+	}
+	return ""
+}
+
+func localAddr(fd int, network string) net.Addr {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil
+	}
+	return sockaddrToAddr(network, sa)
+}
@@ -12,6 +12,8 @@ import (
 	"io"
 	"net"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -27,14 +29,30 @@ const (
 	EPOLLET = 0x80000000
 )
 
+const (
+	// IOModEpoll .
+	IOModEpoll = 0
+
+	// IOModUring .
+	IOModUring = 1
+)
+
 const (
 	epollEventsRead      = syscall.EPOLLPRI | syscall.EPOLLIN
 	epollEventsWrite     = syscall.EPOLLOUT
 	epollEventsReadWrite = syscall.EPOLLPRI | syscall.EPOLLIN | syscall.EPOLLOUT
 	epollEventsError     = syscall.EPOLLERR | syscall.EPOLLHUP | syscall.EPOLLRDHUP
 
-	epollEventsReadET      = syscall.EPOLLPRI | syscall.EPOLLIN | EPOLLET
+	// in EPOLLET mod, write interest is registered with read interest once and stays registered.
 	epollEventsReadWriteET = syscall.EPOLLPRI | syscall.EPOLLIN | syscall.EPOLLOUT | EPOLLET
+
+	// syscall does not export EPOLLEXCLUSIVE.
+	epollExclusive = 1 << 28
+
+	maxAcceptTimesPerEventLoop = 64
+
+	// acceptRetryInterval is how long accepting waits after a temporary error such as EMFILE.
+	acceptRetryInterval = time.Second / 20
 )
 
 type poller struct {
@@ -45,24 +63,65 @@ type poller struct {
 
 	index int
 
-	shutdown bool
+	// connNum is the number of conns served by this poller.
+	connNum int64
+
+	// statistics, updated atomically.
+	wakeups      uint64
+	bytesRead    uint64
+	bytesWritten uint64
+
+	// shutdown is set to 1 by stop, accessed atomically.
+	shutdown int32
 
-	listener   net.Listener
+	// timers and calls run by the poller goroutine, guarded by tmux.
+	// waiting is set while the goroutine waits for events until waitUntil, zero for no timeout.
+	tmux          sync.Mutex
+	timers        timerQueue
+	callings      []func()
+	waiting       bool
+	waitUntil     time.Time
+	timersStopped bool
+
+	lfd        int
+	laddr      net.Addr
 	isListener bool
 
+	// adopted is set if lfd is inherited from the parent process or systemd.
+	adopted bool
+
 	ReadBuffer []byte
 
+	ring *ioUring
+
+	// the IORING_OP_TIMEOUT armed for the next timer, uringTimeout is 0 if there's none.
+	uringTimeout   uint64
+	uringTimeoutAt time.Time
+	uringTimespec  syscall.Timespec
+
+	packetBatch *packetBatch
+
 	pollType string
 }
 
 func (p *poller) addConn(c *Conn) {
 	c.g = p.g
+	c.p = p
+	atomic.StoreInt64(&c.openTime, time.Now().UnixNano())
+	p.g.addConnID(c)
+	atomic.AddUint64(&p.g.connsAccepted, 1)
 	p.g.onOpen(c)
 	fd := c.fd
-	p.g.connsUnix[fd] = c
-	err := p.addRead(fd)
+	p.g.connsUnix.set(fd, c)
+	atomic.AddInt64(&p.connNum, 1)
+	var err error
+	if c.isReadPaused() {
+		// PauseRead was called before the conn was added, the read interest is not registered.
+		err = p.addConnEvents(fd, false, c.isWAdded)
+	} else {
+		err = p.addRead(fd)
+	}
 	if err != nil {
-		p.g.connsUnix[fd] = nil
 		c.closeWithError(err)
 		logging.Error("[%v] add read event failed: %v", c.fd, err)
 		return
@@ -70,7 +129,48 @@ func (p *poller) addConn(c *Conn) {
 }
 
 func (p *poller) getConn(fd int) *Conn {
-	return p.g.connsUnix[fd]
+	return p.g.connsUnix.get(fd)
+}
+
+func (p *poller) getListener(fd int) *poller {
+	for _, l := range p.g.listeners {
+		if l.lfd == fd {
+			return l
+		}
+	}
+	return nil
+}
+
+// acceptConns accepts from a nonblocking listening fd inside the poller loop,
+// the new conns are served by this poller.
+func (p *poller) acceptConns(l *poller) {
+	for i := 0; i < maxAcceptTimesPerEventLoop && !l.isShutdown(); i++ {
+		c, err := accept(l.lfd, p.g.network, l.laddr)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ECONNABORTED) || l.isShutdown() {
+				return
+			}
+			if isTemporaryAcceptError(err) {
+				// the pending conn stays in the backlog, the level triggered event would come back at once.
+				logging.Error("Poller[%v_%v_%v] Accept failed: temporary error: %v, retrying...", p.g.Name, p.pollType, p.index, err)
+				p.pauseAccept(l)
+				return
+			}
+			logging.Error("Poller[%v_%v_%v] Accept failed: %v", p.g.Name, p.pollType, p.index, err)
+			return
+		}
+		p.addConn(c)
+	}
+}
+
+// pauseAccept stops waiting on the listening fd in this poller for a while, as acceptorLoop sleeps on temporary errors.
+func (p *poller) pauseAccept(l *poller) {
+	p.deleteEvent(l.lfd)
+	p.afterFunc(acceptRetryInterval, func() {
+		if !l.isShutdown() {
+			p.addListener(l.lfd)
+		}
+	})
 }
 
 func (p *poller) deleteConn(c *Conn) {
@@ -78,8 +178,10 @@ func (p *poller) deleteConn(c *Conn) {
 		return
 	}
 	fd := c.fd
-	if c == p.g.connsUnix[fd] {
-		p.g.connsUnix[fd] = nil
+	p.g.deleteConnID(c)
+	if p.g.connsUnix.delete(fd, c) {
+		atomic.AddInt64(&p.connNum, -1)
+		atomic.AddUint64(&p.g.connsClosed, 1)
 		p.deleteEvent(fd)
 	}
 	p.g.onClose(c, c.closeErr)
@@ -95,10 +197,19 @@ func (p *poller) start() {
 		p.acceptorLoop()
 	} else {
 		defer func() {
-			syscall.Close(p.epfd)
+			p.stopTimers()
+			if p.ring != nil {
+				p.ring.close()
+			} else {
+				syscall.Close(p.epfd)
+			}
 			syscall.Close(p.evtfd)
 		}()
-		p.readWriteLoop()
+		if p.ring != nil {
+			p.uringLoop()
+		} else {
+			p.readWriteLoop()
+		}
 	}
 }
 
@@ -107,28 +218,35 @@ func (p *poller) acceptorLoop() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 	}
+	defer func() {
+		syscall.Close(p.lfd)
+		syscall.Close(p.epfd)
+		syscall.Close(p.evtfd)
+	}()
+
+	events := make([]syscall.EpollEvent, 2)
 
-	p.shutdown = false
-	for !p.shutdown {
-		conn, err := p.listener.Accept()
+	for !p.isShutdown() {
+		c, err := accept(p.lfd, p.g.network, p.laddr)
 		if err == nil {
-			var c *Conn
-			c, err = NBConn(conn)
-			if err != nil {
-				conn.Close()
-				continue
-			}
-			o := p.g.pollers[c.fd%len(p.g.pollers)]
-			o.addConn(c)
-		} else {
-			var ne net.Error
-			if ok := errors.As(err, &ne); ok && ne.Temporary() {
-				logging.Error("Poller[%v_%v_%v] Accept failed: temporary error, retrying...", p.g.Name, p.pollType, p.index)
-				time.Sleep(time.Second / 20)
-			} else {
-				logging.Error("Poller[%v_%v_%v] Accept failed: %v, exit...", p.g.Name, p.pollType, p.index, err)
+			p.g.pickPoller(c).addConn(c)
+		} else if p.isShutdown() {
+			break
+		} else if errors.Is(err, syscall.EAGAIN) {
+			// the listening fd is nonblocking, wait for a new conn or the evtfd written by stop.
+			_, err = syscall.EpollWait(p.epfd, events, -1)
+			if err != nil && !errors.Is(err, syscall.EINTR) {
+				logging.Error("Poller[%v_%v_%v] EpollWait failed: %v, exit...", p.g.Name, p.pollType, p.index, err)
 				break
 			}
+		} else if isTemporaryAcceptError(err) {
+			if !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.ECONNABORTED) {
+				logging.Error("Poller[%v_%v_%v] Accept failed: temporary error: %v, retrying...", p.g.Name, p.pollType, p.index, err)
+				time.Sleep(acceptRetryInterval)
+			}
+		} else {
+			logging.Error("Poller[%v_%v_%v] Accept failed: %v, exit...", p.g.Name, p.pollType, p.index, err)
+			break
 		}
 	}
 }
@@ -139,74 +257,40 @@ func (p *poller) readWriteLoop() {
 		defer runtime.UnlockOSThread()
 	}
 
-	msec := -1
 	events := make([]syscall.EpollEvent, 1024)
+	evtBuf := make([]byte, 8)
 
-	if p.g.onRead == nil && p.g.epollMod == syscall.EPOLLET {
-		p.g.maxReadTimesPerEventLoop = 1<<31 - 1
-	}
+	for !p.isShutdown() {
+		p.runTimers()
 
-	p.shutdown = false
-
-	for !p.shutdown {
+		msec := -1
+		if d, ok := p.beginWait(); ok {
+			msec = durationToMsec(d)
+		}
 		n, err := syscall.EpollWait(p.epfd, events, msec)
+		p.endWait()
 		if err != nil && !errors.Is(err, syscall.EINTR) {
 			return
 		}
 
 		if n <= 0 {
-			msec = -1
-			// runtime.Gosched()
 			continue
 		}
-		msec = 20
+		atomic.AddUint64(&p.wakeups, 1)
 
 		for _, ev := range events[:n] {
 			fd := int(ev.Fd)
 			switch fd {
 			case p.evtfd:
+				syscall.Read(p.evtfd, evtBuf)
 			default:
 				c := p.getConn(fd)
 				if c != nil {
-					if ev.Events&epollEventsError != 0 {
-						c.closeWithError(io.EOF)
-						continue
-					}
-
-					if ev.Events&epollEventsWrite != 0 {
-						c.flush()
-					}
-
-					if ev.Events&epollEventsRead != 0 {
-						if p.g.onRead == nil {
-							for i := 0; i < p.g.maxReadTimesPerEventLoop; i++ {
-								buffer := p.g.borrow(c)
-								n, err := c.Read(buffer)
-								if n > 0 {
-									if c.codec == nil {
-										p.g.onData(c, buffer[:n])
-									} else {
-										c.handlerProtocol(buffer[:n])
-									}
-								}
-								p.g.payback(c, buffer)
-								if errors.Is(err, syscall.EINTR) {
-									continue
-								}
-								if errors.Is(err, syscall.EAGAIN) {
-									break
-								}
-								if err != nil || n == 0 {
-									c.closeWithError(err)
-								}
-								if n < len(buffer) {
-									break
-								}
-							}
-						} else {
-							p.g.onRead(c)
-						}
-					}
+					p.handleEvent(c, ev.Events)
+				} else if l := p.getListener(fd); l != nil {
+					p.acceptConns(l)
+				} else if pc := p.getPacketConn(fd); pc != nil {
+					p.handlePacketEvent(pc, ev.Events)
 				} else {
 					syscall.Close(fd)
 					p.deleteEvent(fd)
@@ -216,24 +300,98 @@ func (p *poller) readWriteLoop() {
 	}
 }
 
+func (p *poller) handleEvent(c *Conn, events uint32) {
+	if events&epollEventsError != 0 {
+		c.closeWithError(io.EOF)
+		return
+	}
+
+	if events&epollEventsWrite != 0 {
+		c.flush()
+	}
+
+	// the event may be reported before PauseRead is called by another goroutine.
+	if events&epollEventsRead != 0 && !c.isReadPaused() {
+		if p.g.onRead == nil {
+			p.readConn(c)
+		} else {
+			p.g.onRead(c)
+		}
+	}
+}
+
+// readConn reads at most maxReadTimesPerEventLoop times in EPOLLLT mod,
+// and until EAGAIN in EPOLLET mod since no more event is reported for data left in the socket.
+func (p *poller) readConn(c *Conn) {
+	et := p.isET()
+	for i := 0; et || i < p.g.maxReadTimesPerEventLoop; i++ {
+		if i > 0 && c.isReadPaused() {
+			// paused by the handler, the data left is reported again after ResumeRead.
+			break
+		}
+		buffer := p.g.borrow(c)
+		n, err := c.Read(buffer)
+		if n > 0 {
+			if c.codec == nil {
+				atomic.AddUint64(&c.framesRead, 1)
+				p.g.onData(c, buffer[:n])
+			} else {
+				c.handlerProtocol(buffer[:n])
+			}
+		}
+		p.g.payback(c, buffer)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EAGAIN) {
+			break
+		}
+		if err != nil || n == 0 {
+			c.closeWithError(err)
+			break
+		}
+		if n < len(buffer) && !et {
+			break
+		}
+	}
+}
+
+func (p *poller) isShutdown() bool {
+	return atomic.LoadInt32(&p.shutdown) == 1
+}
+
+func (p *poller) isET() bool {
+	return p.ring == nil && p.g.epollMod == EPOLLET
+}
+
 func (p *poller) stop() {
 	logging.Debug("Poller[%v_%v_%v] stop...", p.g.Name, p.pollType, p.index)
-	p.shutdown = true
-	if p.listener != nil {
-		p.listener.Close()
-	} else {
-		n := uint64(1)
-		syscall.Write(p.evtfd, (*(*[8]byte)(unsafe.Pointer(&n)))[:])
+	atomic.StoreInt32(&p.shutdown, 1)
+	if p.isListener {
+		if a, ok := p.laddr.(*net.UnixAddr); ok && !p.adopted && !p.g.listenersPassed() {
+			removeUnixSocketFile(a.Name)
+		}
+		if p.g.acceptInPoller {
+			// the pollers may be accepting from the fd now, it's closed by Gopher.stop after they exit.
+			for _, o := range p.g.pollers {
+				o.deleteEvent(p.lfd)
+			}
+			return
+		}
+		// the fd is closed by acceptorLoop.
 	}
+	n := uint64(1)
+	syscall.Write(p.evtfd, (*(*[8]byte)(unsafe.Pointer(&n)))[:])
 }
 
 func (p *poller) addRead(fd int) error {
-	switch p.g.epollMod {
-	case EPOLLET:
-		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsReadET})
-	default:
-		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsRead})
+	if p.ring != nil {
+		return p.ring.addRead(fd)
+	}
+	if p.isET() {
+		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsReadWriteET})
 	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsRead})
 }
 
 // func (p *poller) addWrite(fd int) error {
@@ -241,15 +399,68 @@ func (p *poller) addRead(fd int) error {
 // }
 
 func (p *poller) modWrite(fd int) error {
-	switch p.g.epollMod {
-	case EPOLLET:
-		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsReadWriteET})
-	default:
-		return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsReadWrite})
+	if p.ring != nil {
+		return p.ring.modWrite(fd)
+	}
+	if p.isET() {
+		// write interest has been registered by addRead.
+		return nil
+	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsReadWrite})
+}
+
+// addConnEvents registers a conn fd with the read and write interest set as modConnEvents does.
+func (p *poller) addConnEvents(fd int, read, write bool) error {
+	if p.ring != nil {
+		return p.ring.addEvents(fd, read, write)
 	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, p.connEvents(fd, read, write))
+}
+
+// modConnEvents sets the read and write interest of a conn fd, it's used while the reading is paused.
+func (p *poller) modConnEvents(fd int, read, write bool) error {
+	if p.ring != nil {
+		return p.ring.modEvents(fd, read, write)
+	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, p.connEvents(fd, read, write))
+}
+
+// connEvents returns the epoll events of a conn fd, in EPOLLET mod the write interest stays registered.
+func (p *poller) connEvents(fd int, read, write bool) *syscall.EpollEvent {
+	var events uint32
+	if read {
+		events |= epollEventsRead
+	}
+	if p.isET() {
+		events |= epollEventsWrite | EPOLLET
+	} else if write {
+		events |= epollEventsWrite
+	}
+	return &syscall.EpollEvent{Fd: int32(fd), Events: events}
+}
+
+func (p *poller) addListener(lfd int) error {
+	if p.ring != nil {
+		return p.ring.addRead(lfd)
+	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, lfd, &syscall.EpollEvent{Fd: int32(lfd), Events: syscall.EPOLLIN | epollExclusive})
+}
+
+func (p *poller) resetRead(fd int) error {
+	if p.ring != nil {
+		// write polls are oneshot, nothing left to remove.
+		return nil
+	}
+	if p.isET() {
+		return nil
+	}
+	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Fd: int32(fd), Events: epollEventsRead})
 }
 
 func (p *poller) deleteEvent(fd int) error {
+	if p.ring != nil {
+		return p.ring.deleteEvent(fd)
+	}
 	return syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, &syscall.EpollEvent{Fd: int32(fd)})
 }
 
@@ -259,32 +470,84 @@ func newPoller(g *Gopher, isListener bool, index int) (*poller, error) {
 			panic("invalid listener num")
 		}
 
-		addr := g.addrs[index%len(g.listeners)]
-		ln, err := net.Listen(g.network, addr)
+		addr := g.addrs[index/g.listenerNum]
+		lfd, laddr, err := g.adoptListener(addr)
 		if err != nil {
 			return nil, err
 		}
+		adopted := lfd >= 0
+		if !adopted {
+			lfd, laddr, err = listenSocket(g.network, addr, g.backlogSize, g.listenerNum > 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		g.lfds = append(g.lfds, lfd)
 
 		p := &poller{
 			g:          g,
+			epfd:       -1,
+			evtfd:      -1,
 			index:      index,
-			listener:   ln,
+			lfd:        lfd,
+			laddr:      laddr,
 			isListener: isListener,
+			adopted:    adopted,
 			pollType:   "LISTENER",
 		}
 
+		if !g.acceptInPoller {
+			// the listener goroutine waits on its own epoll fd, so that stop can wake it up by evtfd
+			// without shutting down the listening socket which may be shared with a child process.
+			p.epfd, p.evtfd, err = newEpoll()
+			if err == nil {
+				err = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, lfd, &syscall.EpollEvent{Fd: int32(lfd), Events: syscall.EPOLLIN})
+				if err != nil {
+					syscall.Close(p.epfd)
+					syscall.Close(p.evtfd)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		return p, nil
 	}
 
-	fd, err := syscall.EpollCreate1(0)
+	if g.ioMod == IOModUring {
+		return newUringPoller(g, index)
+	}
+
+	epfd, evtfd, err := newEpoll()
 	if err != nil {
 		return nil, err
 	}
 
-	r0, _, e0 := syscall.Syscall(syscall.SYS_EVENTFD2, 0, syscall.O_NONBLOCK, 0)
+	p := &poller{
+		g:          g,
+		epfd:       epfd,
+		evtfd:      evtfd,
+		index:      index,
+		isListener: isListener,
+		timers:     newTimerQueue(g.timerEngine, g.timerTick),
+		pollType:   "POLLER",
+	}
+
+	return p, nil
+}
+
+// newEpoll creates an epoll fd with an eventfd registered for waking up.
+func newEpoll() (int, int, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	r0, _, e0 := syscall.Syscall(syscall.SYS_EVENTFD2, 0, syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
 	if e0 != 0 {
 		syscall.Close(fd)
-		return nil, err
+		return -1, -1, e0
 	}
 
 	err = syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, int(r0),
@@ -295,17 +558,8 @@ func newPoller(g *Gopher, isListener bool, index int) (*poller, error) {
 	if err != nil {
 		syscall.Close(fd)
 		syscall.Close(int(r0))
-		return nil, err
+		return -1, -1, err
 	}
 
-	p := &poller{
-		g:          g,
-		epfd:       fd,
-		evtfd:      int(r0),
-		index:      index,
-		isListener: isListener,
-		pollType:   "POLLER",
-	}
-
-	return p, nil
+	return fd, int(r0), nil
 }
@@ -0,0 +1,175 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package easyNet
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+// afterFunc adds a timer fired by the poller goroutine.
+func (p *poller) afterFunc(timeout time.Duration, f func()) *htimer {
+	p.tmux.Lock()
+	defer p.tmux.Unlock()
+
+	it := &htimer{
+		index:  -1,
+		expire: time.Now().Add(timeout),
+		f:      f,
+		parent: p,
+	}
+	if p.timers.push(it) {
+		p.wakeup()
+	}
+
+	return it
+}
+
+// removeTimer removes a timer, the poller goroutine is not woken up since its wait can only get longer.
+func (p *poller) removeTimer(it *htimer) {
+	p.tmux.Lock()
+	defer p.tmux.Unlock()
+
+	p.timers.remove(it)
+}
+
+func (p *poller) resetTimer(it *htimer) {
+	p.tmux.Lock()
+	defer p.tmux.Unlock()
+
+	if p.timers.fix(it) {
+		p.wakeup()
+	}
+}
+
+// atOnce queues f to be called by the poller goroutine, f is called by the caller if the poller has stopped.
+func (p *poller) atOnce(f func()) {
+	if f == nil {
+		return
+	}
+	p.tmux.Lock()
+	if p.timersStopped {
+		p.tmux.Unlock()
+		p.call(f, "call")
+		return
+	}
+	p.callings = append(p.callings, f)
+	p.wakeup()
+	p.tmux.Unlock()
+}
+
+// wakeup writes evtfd if the poller goroutine is waiting past the next timer or with calls queued,
+// it must be called with tmux held.
+func (p *poller) wakeup() {
+	if !p.waiting {
+		return
+	}
+	if len(p.callings) == 0 {
+		next, ok := p.timers.next()
+		if !ok || (!p.waitUntil.IsZero() && !next.Before(p.waitUntil)) {
+			return
+		}
+	}
+	p.waiting = false
+	n := uint64(1)
+	syscall.Write(p.evtfd, (*(*[8]byte)(unsafe.Pointer(&n)))[:])
+}
+
+// beginWait returns how long the poller goroutine may wait for events, it returns false to wait forever.
+// other goroutines wake the poller up by evtfd until endWait is called.
+func (p *poller) beginWait() (time.Duration, bool) {
+	p.tmux.Lock()
+	defer p.tmux.Unlock()
+
+	if len(p.callings) > 0 {
+		return 0, true
+	}
+	p.waiting = true
+	next, ok := p.timers.next()
+	if !ok {
+		p.waitUntil = time.Time{}
+		return 0, false
+	}
+	p.waitUntil = next
+	return time.Until(next), true
+}
+
+func (p *poller) endWait() {
+	p.tmux.Lock()
+	p.waiting = false
+	p.tmux.Unlock()
+}
+
+// runTimers calls the queued calls and the timers expired by now,
+// timers added by these calls are left to the next loop so that the poller is not starved.
+func (p *poller) runTimers() {
+	p.tmux.Lock()
+	callings := p.callings
+	p.callings = nil
+	p.tmux.Unlock()
+	for _, f := range callings {
+		p.call(f, "call")
+	}
+
+	now := time.Now()
+	for {
+		p.tmux.Lock()
+		it := p.timers.popExpired(now)
+		p.tmux.Unlock()
+		if it == nil {
+			return
+		}
+		p.call(it.f, "timer")
+	}
+}
+
+// stopTimers calls the queued calls when the poller goroutine exits, pending timers are dropped.
+func (p *poller) stopTimers() {
+	p.tmux.Lock()
+	p.timersStopped = true
+	callings := p.callings
+	p.callings = nil
+	p.tmux.Unlock()
+	for _, f := range callings {
+		p.call(f, "call")
+	}
+}
+
+func (p *poller) pendingTimers() int {
+	p.tmux.Lock()
+	defer p.tmux.Unlock()
+	if p.timers == nil {
+		return 0
+	}
+	return p.timers.Len()
+}
+
+func (p *poller) call(f func(), kind string) {
+	defer func() {
+		err := recover()
+		if err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			logging.Error("Poller[%v_%v_%v] exec %v failed: %v\n%v\n", p.g.Name, p.pollType, p.index, kind, err, *(*string)(unsafe.Pointer(&buf)))
+		}
+	}()
+	f()
+}
+
+// durationToMsec rounds d up to the epoll_wait timeout.
+func durationToMsec(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Millisecond - 1) / time.Millisecond)
+}
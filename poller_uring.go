@@ -0,0 +1,597 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package easyNet
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpPollAdd       = 6
+	ioringOpPollRemove    = 7
+	ioringOpTimeout       = 11
+	ioringOpTimeoutRemove = 12
+
+	ioringEnterGetEvents = 1
+
+	uringEntries = 1024
+
+	// user_data reserved for the poller's eventfd, poll tokens start after it.
+	uringTokenIgnore = 0
+	uringTokenWakeup = 1
+)
+
+type uringSQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringCQOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type uringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        uringSQOffsets
+	cqOff        uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringPoll is an armed oneshot poll request.
+type uringPoll struct {
+	fd    int
+	write bool
+}
+
+// uringArm holds the tokens of the polls armed for a registered fd, 0 if not armed.
+type uringArm struct {
+	read  uint64
+	write uint64
+}
+
+// ioUring is a minimal io_uring instance used as a poll multiplexer:
+// every registered fd has a oneshot POLL_ADD that is re-armed after it completes,
+// re-arms made by the poller goroutine are submitted in one batch per loop iteration.
+type ioUring struct {
+	mux sync.Mutex
+
+	fd int
+
+	sqRing []byte
+	cqRing []byte
+	sqeMem []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqSize  uint32
+	sqArray []uint32
+	sqes    []uringSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []uringCQE
+
+	pending uint32
+	closed  bool
+
+	seq   uint64
+	polls map[uint64]uringPoll
+	armed map[int]*uringArm
+}
+
+func newIOUring(entries uint32) (*ioUring, error) {
+	params := &uringParams{}
+	r0, _, e0 := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(params)), 0)
+	if e0 != 0 {
+		return nil, e0
+	}
+	// the fd must not leak into the child processes the listener fds are passed to.
+	syscall.CloseOnExec(int(r0))
+
+	u := &ioUring{
+		fd:    int(r0),
+		seq:   uringTokenWakeup,
+		polls: map[uint64]uringPoll{},
+		armed: map[int]*uringArm{},
+	}
+
+	var err error
+	sqRingSize := int(params.sqOff.array + params.sqEntries*4)
+	u.sqRing, err = syscall.Mmap(u.fd, ioringOffSQRing, sqRingSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.close()
+		return nil, err
+	}
+	cqRingSize := int(params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(uringCQE{})))
+	u.cqRing, err = syscall.Mmap(u.fd, ioringOffCQRing, cqRingSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.close()
+		return nil, err
+	}
+	sqesSize := int(params.sqEntries * uint32(unsafe.Sizeof(uringSQE{})))
+	u.sqeMem, err = syscall.Mmap(u.fd, ioringOffSQEs, sqesSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err != nil {
+		u.close()
+		return nil, err
+	}
+
+	u.sqHead = (*uint32)(unsafe.Pointer(&u.sqRing[params.sqOff.head]))
+	u.sqTail = (*uint32)(unsafe.Pointer(&u.sqRing[params.sqOff.tail]))
+	u.sqMask = *(*uint32)(unsafe.Pointer(&u.sqRing[params.sqOff.ringMask]))
+	u.sqSize = *(*uint32)(unsafe.Pointer(&u.sqRing[params.sqOff.ringEntries]))
+	u.sqArray = (*[1 << 20]uint32)(unsafe.Pointer(&u.sqRing[params.sqOff.array]))[:params.sqEntries:params.sqEntries]
+	u.sqes = (*[1 << 20]uringSQE)(unsafe.Pointer(&u.sqeMem[0]))[:params.sqEntries:params.sqEntries]
+
+	u.cqHead = (*uint32)(unsafe.Pointer(&u.cqRing[params.cqOff.head]))
+	u.cqTail = (*uint32)(unsafe.Pointer(&u.cqRing[params.cqOff.tail]))
+	u.cqMask = *(*uint32)(unsafe.Pointer(&u.cqRing[params.cqOff.ringMask]))
+	u.cqes = (*[1 << 20]uringCQE)(unsafe.Pointer(&u.cqRing[params.cqOff.cqes]))[:params.cqEntries:params.cqEntries]
+
+	return u, nil
+}
+
+func (u *ioUring) close() {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.closed = true
+	if u.sqeMem != nil {
+		syscall.Munmap(u.sqeMem)
+	}
+	if u.cqRing != nil {
+		syscall.Munmap(u.cqRing)
+	}
+	if u.sqRing != nil {
+		syscall.Munmap(u.sqRing)
+	}
+	syscall.Close(u.fd)
+}
+
+func (u *ioUring) enter(toSubmit uint32, minComplete uint32, flags uint32) (int, error) {
+	r0, _, e0 := syscall.Syscall6(sysIOUringEnter, uintptr(u.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+	if e0 != 0 {
+		return int(r0), e0
+	}
+	return int(r0), nil
+}
+
+// getSQE returns a zeroed sqe, submitting queued entries if the ring is full.
+// must be called with u.mux held.
+func (u *ioUring) getSQE() *uringSQE {
+	for {
+		tail := *u.sqTail
+		if tail-atomic.LoadUint32(u.sqHead) < u.sqSize {
+			idx := tail & u.sqMask
+			sqe := &u.sqes[idx]
+			*sqe = uringSQE{}
+			u.sqArray[idx] = idx
+			atomic.StoreUint32(u.sqTail, tail+1)
+			u.pending++
+			return sqe
+		}
+		if _, err := u.enter(u.pending, 0, 0); err != nil && !errors.Is(err, syscall.EINTR) {
+			logging.Error("io_uring submit failed: %v", err)
+			runtime.Gosched()
+		}
+		u.pending = 0
+	}
+}
+
+// flush submits queued entries, must be called with u.mux held.
+func (u *ioUring) flush() error {
+	if u.pending == 0 {
+		return nil
+	}
+	n := u.pending
+	u.pending = 0
+	_, err := u.enter(n, 0, 0)
+	return err
+}
+
+// pollAdd must be called with u.mux held.
+func (u *ioUring) pollAdd(fd int, events uint32, token uint64) {
+	sqe := u.getSQE()
+	sqe.opcode = ioringOpPollAdd
+	sqe.fd = int32(fd)
+	sqe.opFlags = events
+	sqe.userData = token
+}
+
+// pollRemove must be called with u.mux held.
+func (u *ioUring) pollRemove(token uint64) {
+	delete(u.polls, token)
+	sqe := u.getSQE()
+	sqe.opcode = ioringOpPollRemove
+	sqe.fd = -1
+	sqe.addr = token
+	sqe.userData = uringTokenIgnore
+}
+
+// timeout queues a relative timeout that completes after ts, it returns the token of the timeout.
+// must be called with u.mux held, ts must stay valid until the entry is submitted.
+func (u *ioUring) timeout(ts *syscall.Timespec) uint64 {
+	u.seq++
+	token := u.seq
+	sqe := u.getSQE()
+	sqe.opcode = ioringOpTimeout
+	sqe.fd = -1
+	sqe.addr = uint64(uintptr(unsafe.Pointer(ts)))
+	sqe.len = 1
+	sqe.userData = token
+	return token
+}
+
+// timeoutRemove must be called with u.mux held.
+func (u *ioUring) timeoutRemove(token uint64) {
+	sqe := u.getSQE()
+	sqe.opcode = ioringOpTimeoutRemove
+	sqe.fd = -1
+	sqe.addr = token
+	sqe.userData = uringTokenIgnore
+}
+
+// arm must be called with u.mux held.
+func (u *ioUring) arm(fd int, write bool) uint64 {
+	u.seq++
+	token := u.seq
+	u.polls[token] = uringPoll{fd: fd, write: write}
+	if write {
+		u.pollAdd(fd, epollEventsWrite|epollEventsError, token)
+	} else {
+		u.pollAdd(fd, epollEventsRead|epollEventsError, token)
+	}
+	return token
+}
+
+func (u *ioUring) addRead(fd int) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.closed {
+		return nil
+	}
+	a, ok := u.armed[fd]
+	if !ok {
+		a = &uringArm{}
+		u.armed[fd] = a
+	}
+	if a.read == 0 {
+		a.read = u.arm(fd, false)
+	}
+	return u.flush()
+}
+
+func (u *ioUring) modWrite(fd int) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.closed {
+		return nil
+	}
+	a, ok := u.armed[fd]
+	if !ok {
+		return nil
+	}
+	if a.write == 0 {
+		a.write = u.arm(fd, true)
+	}
+	return u.flush()
+}
+
+// addEvents registers fd and arms its polls as modEvents does.
+func (u *ioUring) addEvents(fd int, read, write bool) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.closed {
+		return nil
+	}
+	a, ok := u.armed[fd]
+	if !ok {
+		a = &uringArm{}
+		u.armed[fd] = a
+	}
+	return u.setEvents(a, fd, read, write)
+}
+
+// modEvents arms or cancels the read poll of fd and arms the write poll if write is set.
+func (u *ioUring) modEvents(fd int, read, write bool) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.closed {
+		return nil
+	}
+	a, ok := u.armed[fd]
+	if !ok {
+		return nil
+	}
+	return u.setEvents(a, fd, read, write)
+}
+
+// setEvents must be called with u.mux held.
+func (u *ioUring) setEvents(a *uringArm, fd int, read, write bool) error {
+	if read && a.read == 0 {
+		a.read = u.arm(fd, false)
+	} else if !read && a.read != 0 {
+		u.pollRemove(a.read)
+		a.read = 0
+	}
+	if write && a.write == 0 {
+		a.write = u.arm(fd, true)
+	}
+	return u.flush()
+}
+
+func (u *ioUring) deleteEvent(fd int) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.closed {
+		return nil
+	}
+	a, ok := u.armed[fd]
+	if !ok {
+		return nil
+	}
+	delete(u.armed, fd)
+	if a.read != 0 {
+		u.pollRemove(a.read)
+	}
+	if a.write != 0 {
+		u.pollRemove(a.write)
+	}
+	return u.flush()
+}
+
+// rearm is used by the poller goroutine, the sqe is submitted with the next wait.
+func (u *ioUring) rearm(fd int, write bool) {
+	u.mux.Lock()
+	a, ok := u.armed[fd]
+	if ok {
+		if write && a.write == 0 {
+			a.write = u.arm(fd, true)
+		} else if !write && a.read == 0 {
+			a.read = u.arm(fd, false)
+		}
+	}
+	u.mux.Unlock()
+}
+
+// complete resolves a token to its poll and marks it as not armed.
+func (u *ioUring) complete(token uint64) (uringPoll, bool) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	poll, ok := u.polls[token]
+	if !ok {
+		return poll, false
+	}
+	delete(u.polls, token)
+	if a, ok := u.armed[poll.fd]; ok {
+		if poll.write && a.write == token {
+			a.write = 0
+		} else if !poll.write && a.read == token {
+			a.read = 0
+		}
+	}
+	return poll, true
+}
+
+// wait submits all queued entries and waits for at least one completion.
+func (u *ioUring) wait() error {
+	u.mux.Lock()
+	n := u.pending
+	u.pending = 0
+	u.mux.Unlock()
+	_, err := u.enter(n, 1, ioringEnterGetEvents)
+	return err
+}
+
+// reap calls h for every completion that is ready.
+func (u *ioUring) reap(h func(cqe *uringCQE)) {
+	head := *u.cqHead
+	tail := atomic.LoadUint32(u.cqTail)
+	for ; head != tail; head++ {
+		cqe := u.cqes[head&u.cqMask]
+		atomic.StoreUint32(u.cqHead, head+1)
+		h(&cqe)
+	}
+}
+
+func newUringPoller(g *Gopher, index int) (*poller, error) {
+	u, err := newIOUring(uringEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	r0, _, e0 := syscall.Syscall(syscall.SYS_EVENTFD2, 0, syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if e0 != 0 {
+		u.close()
+		return nil, e0
+	}
+
+	u.mux.Lock()
+	u.pollAdd(int(r0), syscall.EPOLLIN, uringTokenWakeup)
+	err = u.flush()
+	u.mux.Unlock()
+	if err != nil {
+		u.close()
+		syscall.Close(int(r0))
+		return nil, err
+	}
+
+	p := &poller{
+		g:        g,
+		evtfd:    int(r0),
+		index:    index,
+		ring:     u,
+		timers:   newTimerQueue(g.timerEngine, g.timerTick),
+		pollType: "POLLER",
+	}
+
+	return p, nil
+}
+
+func (p *poller) uringLoop() {
+	if p.g.lockPoller {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+
+	u := p.ring
+	evtBuf := make([]byte, 8)
+
+	for !p.isShutdown() {
+		p.runTimers()
+
+		if d, ok := p.beginWait(); ok {
+			p.armUringTimeout(d)
+		}
+		err := u.wait()
+		p.endWait()
+		if err != nil && !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.EBUSY) {
+			logging.Error("Poller[%v_%v_%v] io_uring wait failed: %v", p.g.Name, p.pollType, p.index, err)
+			return
+		}
+		atomic.AddUint64(&p.wakeups, 1)
+
+		u.reap(func(cqe *uringCQE) {
+			switch cqe.userData {
+			case uringTokenIgnore:
+				return
+			case uringTokenWakeup:
+				syscall.Read(p.evtfd, evtBuf)
+				if !p.isShutdown() {
+					u.mux.Lock()
+					u.pollAdd(p.evtfd, syscall.EPOLLIN, uringTokenWakeup)
+					u.mux.Unlock()
+				}
+				return
+			case p.uringTimeout:
+				p.uringTimeout = 0
+				return
+			}
+
+			poll, ok := u.complete(cqe.userData)
+			if !ok {
+				return
+			}
+
+			c := p.getConn(poll.fd)
+			if c == nil {
+				if l := p.getListener(poll.fd); l != nil && cqe.res >= 0 {
+					p.acceptConns(l)
+					u.rearm(poll.fd, false)
+				} else if pc := p.getPacketConn(poll.fd); pc != nil && cqe.res >= 0 {
+					p.handlePacketEvent(pc, uint32(cqe.res))
+					if !poll.write || pc.writePending() {
+						u.rearm(poll.fd, poll.write)
+					}
+				}
+				return
+			}
+
+			events := uint32(cqe.res)
+			if cqe.res < 0 {
+				if cqe.res == -int32(syscall.ECANCELED) {
+					return
+				}
+				events = syscall.EPOLLERR
+			}
+			p.handleEvent(c, events)
+
+			if poll.write {
+				c.mux.Lock()
+				pending := !c.closed && c.hasPending()
+				c.mux.Unlock()
+				if pending {
+					u.rearm(poll.fd, true)
+				}
+			} else {
+				// checked with c.mux held so that a concurrent PauseRead is not undone.
+				c.mux.Lock()
+				if !c.isReadPaused() {
+					u.rearm(poll.fd, false)
+				}
+				c.mux.Unlock()
+			}
+		})
+	}
+}
+
+// armUringTimeout makes the next wait return after d, a timeout armed for a later time is replaced.
+func (p *poller) armUringTimeout(d time.Duration) {
+	at := time.Now().Add(d)
+	if p.uringTimeout != 0 && !at.Before(p.uringTimeoutAt) {
+		return
+	}
+
+	u := p.ring
+	u.mux.Lock()
+	if p.uringTimeout != 0 {
+		u.timeoutRemove(p.uringTimeout)
+	}
+	p.uringTimespec = syscall.NsecToTimespec(int64(d))
+	p.uringTimeout = u.timeout(&p.uringTimespec)
+	p.uringTimeoutAt = at
+	u.mux.Unlock()
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package easyNet
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// maxSendFileSize is the max bytes sent by one sendfile call.
+const maxSendFileSize = 4 << 20
+
+// sendFileJob is a file range queued by SendFile, fd is dup'd from the file so that the caller can close it.
+type sendFileJob struct {
+	fd     int
+	offset int64
+	remain int64
+
+	// tail is the data written after the file.
+	tail []byte
+}
+
+// SendFile sends n bytes of f from offset with sendfile(2), n < 0 means to the end of f.
+// the range left when the kernel sendQ is full is sent by the poller later, as data written by Write,
+// so f can be closed once SendFile returns. it returns the bytes sent or queued.
+func (c *Conn) SendFile(f *os.File, offset, n int64) (int64, error) {
+	return c.SendFileWithCallback(f, offset, n, nil)
+}
+
+// SendFileWithCallback sends a file range like SendFile, cb is called once the range is written to the kernel,
+// or with the close error if the conn is closed before that. cb is not called if an error is returned.
+func (c *Conn) SendFileWithCallback(f *os.File, offset, n int64, cb func(err error)) (int64, error) {
+	return c.sendFile(f, offset, n, cb)
+}
+
+// sendFileSource is implemented by *os.File, and by the wrapper of it that (*os.File).WriteTo passes to io.Copy,
+// so that io.Copy(c, f) is sent by sendfile as well.
+type sendFileSource interface {
+	io.ReadSeeker
+	Stat() (os.FileInfo, error)
+	SyscallConn() (syscall.RawConn, error)
+}
+
+func (c *Conn) sendFile(f sendFileSource, offset, n int64, cb func(err error)) (int64, error) {
+	if n < 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		n = fi.Size() - offset
+		if n < 0 {
+			n = 0
+		}
+	}
+	sc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return 0, errClosed
+	}
+
+	c.g.beforeWrite(c)
+
+	job := &sendFileJob{fd: -1, offset: offset, remain: n}
+	cerr := sc.Control(func(fd uintptr) {
+		if !c.hasPending() {
+			_, err = c.sendFileRange(int(fd), job)
+			if err != nil && !errors.Is(err, syscall.EINTR) && !errors.Is(err, syscall.EAGAIN) {
+				return
+			}
+			err = nil
+		}
+		if job.remain > 0 {
+			job.fd, err = dupCloseOnExec(int(fd))
+		}
+	})
+	if cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		if job.remain == n {
+			// nothing is sent, the conn is left as it was.
+			c.mux.Unlock()
+			return 0, err
+		}
+		c.closed = true
+		c.mux.Unlock()
+		c.closeWithErrorWithoutLock(err)
+		return n - job.remain, err
+	}
+	if job.remain > 0 {
+		c.sendFiles = append(c.sendFiles, job)
+	}
+	c.onFrameWritten()
+
+	if !c.hasPending() {
+		if c.wTimer != nil {
+			c.wTimer.Stop()
+			c.wTimer = nil
+		}
+	} else {
+		c.modWrite()
+	}
+	if cb != nil && !c.addWriteCallback(cb) {
+		cb = nil
+	}
+
+	c.mux.Unlock()
+	if cb != nil {
+		cb(nil)
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom, the data of *os.File and *io.LimitedReader of *os.File is sent by SendFile
+// from the current offset of the file, which is moved forward as read. io.Copy(c, f) gets here through
+// (*os.File).WriteTo with f wrapped, which is recognized by the methods of *os.File it keeps.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	src, n := r, int64(-1)
+	lr, limited := r.(*io.LimitedReader)
+	if limited {
+		src, n = lr.R, lr.N
+		if n <= 0 {
+			return 0, nil
+		}
+	}
+	f, ok := src.(sendFileSource)
+	if !ok {
+		return io.Copy(writerOnly{c}, r)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !fi.Mode().IsRegular() {
+		return io.Copy(writerOnly{c}, r)
+	}
+
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if left := fi.Size() - offset; n < 0 || n > left {
+		n = left
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+	sent, err := c.sendFile(f, offset, n, nil)
+	if sent > 0 {
+		if _, serr := f.Seek(sent, io.SeekCurrent); serr != nil && err == nil {
+			err = serr
+		}
+		if limited {
+			lr.N -= sent
+		}
+	}
+	return sent, err
+}
+
+// writerOnly hides ReadFrom of Conn from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+// flushSendFile sends the first queued file, it returns true if the file and its tail are moved out of the queue.
+// it should be called with c.mux held and writeBuffer empty.
+func (c *Conn) flushSendFile() (bool, error) {
+	job := c.sendFiles[0]
+	complete, err := c.sendFileRange(job.fd, job)
+	if !complete {
+		return false, err
+	}
+	syscall.Close(job.fd)
+	c.sendFiles[0] = nil
+	c.sendFiles = c.sendFiles[1:]
+	if len(c.sendFiles) == 0 {
+		c.sendFiles = nil
+	}
+	c.writeBuffer = job.tail
+	return true, nil
+}
+
+// sendFileRange sends the range of job from fd until it's done or the kernel sendQ is full,
+// it returns true if the range is fully sent. it should be called with c.mux held.
+func (c *Conn) sendFileRange(fd int, job *sendFileJob) (bool, error) {
+	for job.remain > 0 {
+		size := job.remain
+		if size > maxSendFileSize {
+			size = maxSendFileSize
+		}
+		offset := job.offset
+		n, err := syscall.Sendfile(c.fd, fd, &offset, int(size))
+		if n > 0 {
+			job.offset += int64(n)
+			job.remain -= int64(n)
+			c.onWritten(n)
+		}
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) {
+				c.partialWrites++
+			}
+			return false, err
+		}
+		if n == 0 {
+			// the file is shorter than the range, the peer would get corrupted data.
+			return false, io.ErrUnexpectedEOF
+		}
+		if int64(n) < size {
+			c.partialWrites++
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// closeSendFiles closes the queued files, it's called when the conn is closed.
+func (c *Conn) closeSendFiles() {
+	for _, job := range c.sendFiles {
+		syscall.Close(job.fd)
+	}
+	c.sendFiles = nil
+}
+
+func dupCloseOnExec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	nfd, err := syscall.Dup(fd)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(nfd)
+	return nfd, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Stats represents the runtime statistics of a Gopher.
+type Stats struct {
+	// ConnsAccepted and ConnsClosed are the numbers of conns added to and removed from pollers.
+	ConnsAccepted uint64
+	ConnsClosed   uint64
+
+	// Conns is the number of current conns, PollerConns is that of each poller.
+	Conns       int
+	PollerConns []int
+
+	// BytesRead and BytesWritten are the bytes read from and written to the sockets.
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// WriteBufferBytes and CacheBufferBytes are the bytes held by write buffers and codec caches.
+	WriteBufferBytes int
+	CacheBufferBytes int
+
+	// PollerWakeups is the number of returns with events from epoll_wait or io_uring_enter of each poller.
+	PollerWakeups []uint64
+
+	// PendingTimers is the number of timers not fired yet, including the deadlines held by pollers.
+	PendingTimers int
+
+	// PendingExecutes is the number of Conn.Execute and worker pool jobs not done yet.
+	PendingExecutes int
+}
+
+// Stats returns the runtime statistics, the buffer gauges are collected by visiting every conn.
+func (g *Gopher) Stats() Stats {
+	st := Stats{
+		ConnsAccepted: atomic.LoadUint64(&g.connsAccepted),
+		ConnsClosed:   atomic.LoadUint64(&g.connsClosed),
+	}
+	g.pollerStats(&st)
+
+	g.Range(func(c *Conn) bool {
+		st.Conns++
+		c.mux.Lock()
+		st.WriteBufferBytes += c.writeBufferLen()
+		st.CacheBufferBytes += c.cacheBufferLen()
+		st.PendingExecutes += len(c.execList)
+		c.mux.Unlock()
+		return true
+	})
+
+	st.PendingTimers += g.pendingTimers()
+
+	if g.workers != nil {
+		ws := g.workers.stats()
+		st.PendingExecutes += ws.QueueLen + ws.Busy
+	}
+
+	return st
+}
+
+// StatsHandler returns a http.Handler that renders Stats in the Prometheus text format.
+func (g *Gopher) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(g.Stats().prometheus(g.Name))
+	})
+}
+
+// prometheusLabelEscaper escapes a label value of the Prometheus text format, in which only
+// backslash, double quote and line feed are escaped.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (st Stats) prometheus(name string) []byte {
+	buf := &bytes.Buffer{}
+	label := `gopher="` + prometheusLabelEscaper.Replace(name) + `"`
+	metric := func(metric, typ, help string) {
+		fmt.Fprintf(buf, "# HELP easynet_%v %v\n# TYPE easynet_%v %v\n", metric, help, metric, typ)
+	}
+	value := func(metric string, labels string, v interface{}) {
+		fmt.Fprintf(buf, "easynet_%v{%v} %v\n", metric, labels, v)
+	}
+
+	metric("conns_accepted_total", "counter", "Conns added to pollers.")
+	value("conns_accepted_total", label, st.ConnsAccepted)
+	metric("conns_closed_total", "counter", "Conns removed from pollers.")
+	value("conns_closed_total", label, st.ConnsClosed)
+	metric("conns", "gauge", "Current conns.")
+	value("conns", label, st.Conns)
+	metric("poller_conns", "gauge", "Current conns of each poller.")
+	for i, n := range st.PollerConns {
+		value("poller_conns", fmt.Sprintf(`%v,poller="%v"`, label, i), n)
+	}
+	metric("poller_wakeups_total", "counter", "Wakeups with events of each poller.")
+	for i, n := range st.PollerWakeups {
+		value("poller_wakeups_total", fmt.Sprintf(`%v,poller="%v"`, label, i), n)
+	}
+	metric("read_bytes_total", "counter", "Bytes read from sockets.")
+	value("read_bytes_total", label, st.BytesRead)
+	metric("written_bytes_total", "counter", "Bytes written to sockets.")
+	value("written_bytes_total", label, st.BytesWritten)
+	metric("write_buffer_bytes", "gauge", "Bytes waiting in write buffers.")
+	value("write_buffer_bytes", label, st.WriteBufferBytes)
+	metric("cache_buffer_bytes", "gauge", "Bytes held by codec caches.")
+	value("cache_buffer_bytes", label, st.CacheBufferBytes)
+	metric("pending_timers", "gauge", "Timers not fired yet.")
+	value("pending_timers", label, st.PendingTimers)
+	metric("pending_executes", "gauge", "Execute jobs not done yet.")
+	value("pending_executes", label, st.PendingExecutes)
+
+	return buf.Bytes()
+}
@@ -5,6 +5,7 @@
 package easyNet
 
 import (
+	"container/heap"
 	"math"
 	"time"
 )
@@ -13,6 +14,15 @@ const (
 	timeForever = time.Duration(math.MaxInt64)
 )
 
+const (
+	// TimerEngineHeap keeps timers in a min heap, it's precise and suits a small number of timers.
+	TimerEngineHeap = 0
+
+	// TimerEngineWheel keeps timers in a hierarchical timing wheel, adding, resetting and removing
+	// a timer are O(1), timers fire at the granularity of Config.TimerTick.
+	TimerEngineWheel = 1
+)
+
 // Timer type for export.
 type Timer struct {
 	*htimer
@@ -23,7 +33,19 @@ type htimer struct {
 	index  int
 	expire time.Time
 	f      func()
-	parent *Gopher
+	parent timerOwner
+
+	// used by timerWheel.
+	prev  *htimer
+	next  *htimer
+	list  *timerList
+	level int
+}
+
+// timerOwner runs timers, it's the Gopher or the poller that created the timer.
+type timerOwner interface {
+	removeTimer(it *htimer)
+	resetTimer(it *htimer)
 }
 
 // cancel timer.
@@ -37,6 +59,29 @@ func (it *htimer) Reset(timeout time.Duration) {
 	it.parent.resetTimer(it)
 }
 
+// timerQueue is a timer engine, it's guarded by the tmux of its owner.
+type timerQueue interface {
+	// Len returns the number of pending timers.
+	Len() int
+	// push adds a timer, it returns true if the next trigger time may be changed.
+	push(it *htimer) bool
+	// remove removes a timer if it's pending, it returns true if the next trigger time may be changed.
+	remove(it *htimer) bool
+	// fix reschedules a pending timer after its expire changed, it returns true if the next trigger time may be changed.
+	fix(it *htimer) bool
+	// next returns the time to check expired timers, it returns false if there's no timer.
+	next() (time.Time, bool)
+	// popExpired removes and returns a timer expired before now, it returns nil if there's none.
+	popExpired(now time.Time) *htimer
+}
+
+func newTimerQueue(engine int, tick time.Duration) timerQueue {
+	if engine == TimerEngineWheel {
+		return newTimerWheel(tick)
+	}
+	return &timerHeap{}
+}
+
 type timerHeap []*htimer
 
 func (h timerHeap) Len() int           { return len(h) }
@@ -60,3 +105,43 @@ func (h *timerHeap) Pop() interface{} {
 	*h = old[0 : n-1]
 	return x
 }
+
+func (h *timerHeap) push(it *htimer) bool {
+	heap.Push(h, it)
+	return (*h)[0] == it
+}
+
+func (h *timerHeap) remove(it *htimer) bool {
+	index := it.index
+	if index < 0 || index >= len(*h) || (*h)[index] != it {
+		return false
+	}
+	heap.Remove(h, index)
+	it.index = -1
+	return index == 0 || len(*h) == 0
+}
+
+func (h *timerHeap) fix(it *htimer) bool {
+	index := it.index
+	if index < 0 || index >= len(*h) || (*h)[index] != it {
+		return false
+	}
+	heap.Fix(h, index)
+	return index == 0 || it.index == 0
+}
+
+func (h *timerHeap) next() (time.Time, bool) {
+	if len(*h) == 0 {
+		return time.Time{}, false
+	}
+	return (*h)[0].expire, true
+}
+
+func (h *timerHeap) popExpired(now time.Time) *htimer {
+	if len(*h) == 0 || !now.After((*h)[0].expire) {
+		return nil
+	}
+	it := heap.Pop(h).(*htimer)
+	it.index = -1
+	return it
+}
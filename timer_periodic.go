@@ -0,0 +1,120 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// TickFixedRate schedules the ticks at start + n*interval, the ticks missed by a long running callback are skipped.
+	TickFixedRate = 0
+
+	// TickFixedDelay schedules the next tick interval after the callback returns.
+	TickFixedDelay = 1
+)
+
+// PeriodicTimer calls a function repeatedly on the Gopher timer engine until it's stopped.
+type PeriodicTimer struct {
+	mux sync.Mutex
+
+	g        *Gopher
+	it       *htimer
+	f        func()
+	mode     int
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+// Ticker delivers ticks to C like time.Ticker, ticks are dropped if C is not read in time.
+type Ticker struct {
+	C <-chan time.Time
+
+	pt *PeriodicTimer
+}
+
+// Every calls f every interval in TickFixedRate mode.
+func (g *Gopher) Every(interval time.Duration, f func()) *PeriodicTimer {
+	return g.EveryWithMode(interval, TickFixedRate, f)
+}
+
+// EveryWithMode calls f every interval in mode, TickFixedRate or TickFixedDelay.
+func (g *Gopher) EveryWithMode(interval time.Duration, mode int, f func()) *PeriodicTimer {
+	if interval <= 0 {
+		panic("invalid interval")
+	}
+	pt := &PeriodicTimer{
+		g:        g,
+		f:        f,
+		mode:     mode,
+		interval: interval,
+		next:     time.Now().Add(interval),
+	}
+	pt.mux.Lock()
+	pt.it = g.afterFunc(interval, pt.fire)
+	pt.mux.Unlock()
+	return pt
+}
+
+// Ticker returns a Ticker in TickFixedRate mode.
+func (g *Gopher) Ticker(interval time.Duration) *Ticker {
+	return g.TickerWithMode(interval, TickFixedRate)
+}
+
+// TickerWithMode returns a Ticker in mode, TickFixedRate or TickFixedDelay.
+func (g *Gopher) TickerWithMode(interval time.Duration, mode int) *Ticker {
+	c := make(chan time.Time, 1)
+	pt := g.EveryWithMode(interval, mode, func() {
+		select {
+		case c <- time.Now():
+		default:
+		}
+	})
+	return &Ticker{C: c, pt: pt}
+}
+
+// Stop stops the ticker, C is not closed.
+func (t *Ticker) Stop() {
+	t.pt.Stop()
+}
+
+// Stop stops the timer, f is not called any more after Stop returns unless it's running.
+func (pt *PeriodicTimer) Stop() {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	pt.stopped = true
+	if pt.it != nil {
+		pt.it.Stop()
+		pt.it = nil
+	}
+}
+
+func (pt *PeriodicTimer) fire() {
+	// rescheduled even if f panics, the panic is logged by the timer goroutine.
+	defer pt.schedule()
+	pt.f()
+}
+
+func (pt *PeriodicTimer) schedule() {
+	pt.mux.Lock()
+	defer pt.mux.Unlock()
+	if pt.stopped {
+		return
+	}
+
+	now := time.Now()
+	if pt.mode == TickFixedDelay {
+		pt.next = now.Add(pt.interval)
+	} else {
+		pt.next = pt.next.Add(pt.interval)
+		if !pt.next.After(now) {
+			missed := now.Sub(pt.next)/pt.interval + 1
+			pt.next = pt.next.Add(missed * pt.interval)
+		}
+	}
+	pt.it = pt.g.afterFunc(pt.next.Sub(now), pt.fire)
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"time"
+)
+
+const (
+	// DefaultTimerTick .
+	DefaultTimerTick = time.Millisecond
+
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 5
+
+	// wheelMaxDelta is the max ticks a timer can be scheduled ahead, a timer later than it
+	// is scheduled at the max and rescheduled when that tick comes.
+	wheelMaxDelta = 1<<(wheelBits*wheelLevels) - 1
+)
+
+// timerList is a doubly linked list of timers.
+type timerList struct {
+	head *htimer
+	tail *htimer
+}
+
+func (l *timerList) pushBack(it *htimer) {
+	it.list = l
+	it.prev = l.tail
+	it.next = nil
+	if l.tail != nil {
+		l.tail.next = it
+	} else {
+		l.head = it
+	}
+	l.tail = it
+}
+
+func (l *timerList) remove(it *htimer) {
+	if it.prev != nil {
+		it.prev.next = it.next
+	} else {
+		l.head = it.next
+	}
+	if it.next != nil {
+		it.next.prev = it.prev
+	} else {
+		l.tail = it.prev
+	}
+	it.prev, it.next, it.list = nil, nil, nil
+}
+
+// take removes and returns all timers as a singly linked list by next.
+func (l *timerList) take() *htimer {
+	head := l.head
+	l.head, l.tail = nil, nil
+	return head
+}
+
+// timerWheel is a hierarchical timing wheel of wheelLevels levels with wheelSize slots each,
+// slots of level n span wheelSize^n ticks and are cascaded to lower levels when their time comes.
+type timerWheel struct {
+	tick  time.Duration
+	start time.Time
+
+	// current is the last tick processed.
+	current uint64
+
+	n      int
+	counts [wheelLevels]int
+	slots  [wheelLevels][wheelSize]timerList
+
+	// expired holds the timers due but not popped yet.
+	expired timerList
+}
+
+func newTimerWheel(tick time.Duration) *timerWheel {
+	if tick <= 0 {
+		tick = DefaultTimerTick
+	}
+	return &timerWheel{
+		tick:  tick,
+		start: time.Now(),
+	}
+}
+
+func (w *timerWheel) Len() int {
+	return w.n
+}
+
+func (w *timerWheel) push(it *htimer) bool {
+	w.add(it)
+	w.n++
+	return true
+}
+
+func (w *timerWheel) remove(it *htimer) bool {
+	if it.list == nil {
+		return false
+	}
+	if it.level >= 0 {
+		w.counts[it.level]--
+	}
+	it.list.remove(it)
+	w.n--
+	return w.n == 0
+}
+
+func (w *timerWheel) fix(it *htimer) bool {
+	if it.list == nil {
+		return false
+	}
+	if it.level >= 0 {
+		w.counts[it.level]--
+	}
+	it.list.remove(it)
+	w.add(it)
+	return true
+}
+
+func (w *timerWheel) next() (time.Time, bool) {
+	if w.n == 0 {
+		return time.Time{}, false
+	}
+	if w.expired.head != nil {
+		return w.timeOf(w.current), true
+	}
+	return w.timeOf(w.nextTick()), true
+}
+
+func (w *timerWheel) popExpired(now time.Time) *htimer {
+	target := w.tickOf(now)
+	for {
+		for w.expired.head == nil && w.current < target {
+			next := w.nextTick()
+			if next > target {
+				// nothing to do before target.
+				w.current = target
+				break
+			}
+			w.current = next - 1
+			w.advance()
+		}
+
+		it := w.expired.head
+		if it == nil {
+			return nil
+		}
+		w.expired.remove(it)
+		if it.expire.After(now) {
+			// scheduled at wheelMaxDelta, not expired yet.
+			w.add(it)
+			continue
+		}
+		w.n--
+		return it
+	}
+}
+
+// add schedules it without changing n.
+func (w *timerWheel) add(it *htimer) {
+	exp := w.expireTick(it.expire)
+	if exp <= w.current {
+		it.level = -1
+		w.expired.pushBack(it)
+		return
+	}
+
+	delta := exp - w.current
+	if delta > wheelMaxDelta {
+		delta = wheelMaxDelta
+		exp = w.current + delta
+	}
+	level := 0
+	for delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	it.level = level
+	w.counts[level]++
+	w.slots[level][(exp>>(wheelBits*level))&wheelMask].pushBack(it)
+}
+
+// advance moves to the next tick, cascades the higher level slots whose time comes,
+// and moves the timers of the level 0 slot to expired.
+func (w *timerWheel) advance() {
+	w.current++
+	for level := 1; level < wheelLevels; level++ {
+		if w.current&(1<<(wheelBits*level)-1) != 0 {
+			break
+		}
+		list := &w.slots[level][(w.current>>(wheelBits*level))&wheelMask]
+		for it := list.take(); it != nil; {
+			next := it.next
+			it.prev, it.next, it.list = nil, nil, nil
+			w.counts[level]--
+			w.add(it)
+			it = next
+		}
+	}
+
+	list := &w.slots[0][w.current&wheelMask]
+	for it := list.take(); it != nil; {
+		next := it.next
+		it.prev, it.next, it.list = nil, nil, nil
+		w.counts[0]--
+		it.level = -1
+		w.expired.pushBack(it)
+		it = next
+	}
+}
+
+// nextTick returns the next tick that may have work: the next tick if level 0 has timers,
+// otherwise the next tick cascading the lowest level with timers.
+func (w *timerWheel) nextTick() uint64 {
+	for level := 0; level < wheelLevels; level++ {
+		if w.counts[level] > 0 {
+			span := uint64(1) << (wheelBits * level)
+			return (w.current/span + 1) * span
+		}
+	}
+	return w.current + 1
+}
+
+func (w *timerWheel) tickOf(t time.Time) uint64 {
+	d := t.Sub(w.start)
+	if d <= 0 {
+		return 0
+	}
+	return uint64(d / w.tick)
+}
+
+// expireTick rounds up, so that a timer never fires before its expire.
+func (w *timerWheel) expireTick(t time.Time) uint64 {
+	d := t.Sub(w.start)
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + w.tick - 1) / w.tick)
+}
+
+func (w *timerWheel) timeOf(tick uint64) time.Time {
+	return w.start.Add(time.Duration(tick) * w.tick)
+}
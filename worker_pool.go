@@ -0,0 +1,139 @@
+// Copyright 2020 wubbalubbaaa. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package easyNet
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/wubbalubbaaa/easyNet/logging"
+)
+
+const (
+	// DefaultWorkerQueueSize .
+	DefaultWorkerQueueSize = 1024
+)
+
+const (
+	// WorkerOverflowBlock blocks the caller until a worker is free.
+	WorkerOverflowBlock = 0
+
+	// WorkerOverflowDrop drops the job.
+	WorkerOverflowDrop = 1
+
+	// WorkerOverflowClose drops the job and closes the conn it belongs to,
+	// it works as WorkerOverflowDrop for jobs passed to Gopher.Execute directly.
+	WorkerOverflowClose = 2
+)
+
+// WorkerStats represents the state of the worker pool.
+type WorkerStats struct {
+	Workers   int
+	Busy      int
+	QueueLen  int
+	QueueSize int
+	Dropped   uint64
+}
+
+// workerPool runs jobs on a fixed number of goroutines with a bounded queue.
+type workerPool struct {
+	g *Gopher
+
+	workerNum int
+	overflow  int
+
+	busy    int64
+	dropped uint64
+
+	chJobs chan func()
+	chStop chan struct{}
+
+	wg sync.WaitGroup
+}
+
+func newWorkerPool(g *Gopher, workerNum int, queueSize int, overflow int) *workerPool {
+	return &workerPool{
+		g:         g,
+		workerNum: workerNum,
+		overflow:  overflow,
+		chJobs:    make(chan func(), queueSize),
+		chStop:    make(chan struct{}),
+	}
+}
+
+func (wp *workerPool) start() {
+	for i := 0; i < wp.workerNum; i++ {
+		wp.wg.Add(1)
+		go wp.worker()
+	}
+}
+
+// stop stops workers, the jobs left in the queue are dropped.
+func (wp *workerPool) stop() {
+	close(wp.chStop)
+	wp.wg.Wait()
+}
+
+func (wp *workerPool) worker() {
+	defer wp.wg.Done()
+	for {
+		select {
+		case f := <-wp.chJobs:
+			atomic.AddInt64(&wp.busy, 1)
+			wp.run(f)
+			atomic.AddInt64(&wp.busy, -1)
+		case <-wp.chStop:
+			return
+		}
+	}
+}
+
+func (wp *workerPool) run(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			logging.Error("Gopher[%v] worker exec failed: %v\n%v\n", wp.g.Name, err, *(*string)(unsafe.Pointer(&buf)))
+		}
+	}()
+	f()
+}
+
+// execute queues f, it returns false if f is dropped by the overflow policy.
+func (wp *workerPool) execute(c *Conn, f func()) bool {
+	if wp.overflow == WorkerOverflowBlock {
+		select {
+		case wp.chJobs <- f:
+			return true
+		case <-wp.chStop:
+			return false
+		}
+	}
+
+	select {
+	case wp.chJobs <- f:
+		return true
+	default:
+	}
+
+	atomic.AddUint64(&wp.dropped, 1)
+	if wp.overflow == WorkerOverflowClose && c != nil {
+		c.CloseWithError(errWorkerQueueFull)
+	}
+	return false
+}
+
+func (wp *workerPool) stats() WorkerStats {
+	return WorkerStats{
+		Workers:   wp.workerNum,
+		Busy:      int(atomic.LoadInt64(&wp.busy)),
+		QueueLen:  len(wp.chJobs),
+		QueueSize: cap(wp.chJobs),
+		Dropped:   atomic.LoadUint64(&wp.dropped),
+	}
+}